@@ -0,0 +1,302 @@
+package checkpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Position 表示扫描进度中的一个位置
+type Position struct {
+	Line      int    `json:"line"`       // CIDR 文件行号，从 1 开始
+	Sub       int    `json:"sub"`        // IP 区间拆分出的第几个 CIDR，或域名展开出的第几个 IP
+	StartIP   string `json:"start_ip"`   // ProcessCIDR 批次的起始 IP
+	TaskIndex int    `json:"task_index"` // 端口×路径展开后的任务序号，CIDR 批次中为 IP 在批次中最后一个任务的序号
+}
+
+// State 断点文件内容
+type State struct {
+	CIDRFile  string    `json:"cidr_file"`
+	Position  Position  `json:"position"` // 已全部完成的最后一个位置
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Tracker 记录已完成的扫描位置并定期写入断点文件
+// 所有方法都允许在 nil 上调用，未启用断点时无需判断
+type Tracker struct {
+	mu        sync.Mutex
+	file      string
+	cidrFile  string
+	resume    *Position // 恢复扫描时跳过此位置及之前的任务
+	units     []*Unit   // 按下发顺序排列、尚未提交的单元
+	committed *Position // 已连续完成的最后一个位置
+	dirty     bool
+	stop      chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+// Unit 一个断点单元，对应一批任务，全部完成后才能提交
+type Unit struct {
+	t       *Tracker
+	pos     Position
+	pending int
+	sealed  bool
+	aborted bool // 扫描中断时有任务未执行，该单元及之后的单元都不再提交
+}
+
+// 创建新的断点记录器
+func New(file, cidrFile string) *Tracker {
+	return &Tracker{
+		file:     file,
+		cidrFile: cidrFile,
+		stop:     make(chan struct{}),
+	}
+}
+
+// 从断点文件恢复
+func Load(file, cidrFile string) (*Tracker, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("解析断点文件失败: %v", err)
+	}
+	if state.CIDRFile != cidrFile {
+		return nil, fmt.Errorf("断点文件对应的 CIDR 文件为 %s，与当前配置 %s 不一致", state.CIDRFile, cidrFile)
+	}
+	t := New(file, cidrFile)
+	pos := state.Position
+	t.resume = &pos
+	t.committed = &pos
+	return t, nil
+}
+
+// 是否为断点恢复
+func (t *Tracker) Resuming() bool {
+	return t != nil && t.resume != nil
+}
+
+// 判断整行是否已在上次扫描中完成
+func (t *Tracker) SkipLine(line int) bool {
+	if t == nil || t.resume == nil {
+		return false
+	}
+	return line < t.resume.Line
+}
+
+// 判断 IP 区间拆分出的某个 CIDR 是否已在上次扫描中完成
+func (t *Tracker) SkipSub(line, sub int) bool {
+	if t == nil || t.resume == nil {
+		return false
+	}
+	return line < t.resume.Line || (line == t.resume.Line && sub < t.resume.Sub)
+}
+
+// 判断某个位置是否已在上次扫描中完成
+func (t *Tracker) Skip(pos Position) bool {
+	if t == nil || t.resume == nil {
+		return false
+	}
+	return Compare(pos, *t.resume) <= 0
+}
+
+// 返回上次扫描在该行该 CIDR 中的批次起始 IP，用于直接跳到断点处
+func (t *Tracker) ResumeStartIP(line, sub int) net.IP {
+	if t == nil || t.resume == nil {
+		return nil
+	}
+	if t.resume.Line != line || t.resume.Sub != sub || t.resume.StartIP == "" {
+		return nil
+	}
+	return net.ParseIP(t.resume.StartIP)
+}
+
+// 开始一个新的断点单元，必须按扫描顺序调用
+func (t *Tracker) Begin(pos Position) *Unit {
+	if t == nil {
+		return nil
+	}
+	u := &Unit{t: t, pos: pos}
+	t.mu.Lock()
+	t.units = append(t.units, u)
+	t.mu.Unlock()
+	return u
+}
+
+// 单元内新增一个任务
+func (u *Unit) Add() {
+	if u == nil {
+		return
+	}
+	u.t.mu.Lock()
+	u.pending++
+	u.t.mu.Unlock()
+}
+
+// 单元内一个任务执行完成
+func (u *Unit) Done() {
+	if u == nil {
+		return
+	}
+	u.t.mu.Lock()
+	u.pending--
+	u.t.commitLocked()
+	u.t.mu.Unlock()
+}
+
+// 单元内的任务已全部下发
+func (u *Unit) Seal() {
+	if u == nil {
+		return
+	}
+	u.t.mu.Lock()
+	u.sealed = true
+	u.t.commitLocked()
+	u.t.mu.Unlock()
+}
+
+// 扫描中断，单元内有任务没有执行
+func (u *Unit) Abort() {
+	if u == nil {
+		return
+	}
+	u.t.mu.Lock()
+	u.aborted = true
+	u.t.mu.Unlock()
+}
+
+// 按顺序提交已完成的单元，遇到中断的单元后停止提交
+func (t *Tracker) commitLocked() {
+	for len(t.units) > 0 {
+		u := t.units[0]
+		if u.aborted || !u.sealed || u.pending > 0 {
+			return
+		}
+		pos := u.pos
+		t.committed = &pos
+		t.dirty = true
+		t.units[0] = nil
+		t.units = t.units[1:]
+	}
+}
+
+// 定期保存断点文件
+func (t *Tracker) Start(interval time.Duration) {
+	if t == nil {
+		return
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := t.Save(); err != nil {
+					log.Printf("保存断点文件失败: %v\n", err)
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+// 将已提交的位置写入断点文件
+func (t *Tracker) Save() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.dirty || t.committed == nil {
+		t.mu.Unlock()
+		return nil
+	}
+	state := State{
+		CIDRFile:  t.cidrFile,
+		Position:  *t.committed,
+		UpdatedAt: time.Now(),
+	}
+	t.dirty = false
+	t.mu.Unlock()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免中途断电留下损坏的断点文件
+	tmp := t.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.file)
+}
+
+// 停止定期保存，扫描全部完成时删除断点文件
+func (t *Tracker) Close(finished bool) error {
+	if t == nil {
+		return nil
+	}
+	t.stopOnce.Do(func() { close(t.stop) })
+	t.wg.Wait()
+	if finished {
+		err := os.Remove(t.file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return t.Save()
+}
+
+// 比较两个位置的先后，a 在前返回 -1，相同返回 0，a 在后返回 1
+func Compare(a, b Position) int {
+	if c := compareInt(a.Line, b.Line); c != 0 {
+		return c
+	}
+	if c := compareInt(a.Sub, b.Sub); c != 0 {
+		return c
+	}
+	if c := compareIP(a.StartIP, b.StartIP); c != 0 {
+		return c
+	}
+	return compareInt(a.TaskIndex, b.TaskIndex)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// 比较 IP 字符串，空字符串排在最前
+func compareIP(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return -1
+	}
+	if b == "" {
+		return 1
+	}
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return strings.Compare(a, b)
+	}
+	return bytes.Compare(ipA.To16(), ipB.To16())
+}
@@ -0,0 +1,136 @@
+package cidr
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/util"
+)
+
+// 统计 CIDR 文件展开后的任务总数，用于显示进度
+// 不做域名解析，无法解析的域名也计入总数
+func CountTasks(cfg *config.Config) (int64, error) {
+	file, err := os.Open(cfg.CIDRFile)
+	if err != nil {
+		return 0, fmt.Errorf("打开CIDR文件失败: %v", err)
+	}
+	defer file.Close()
+
+	var total int64
+	lineScanner := bufio.NewScanner(file)
+	for lineScanner.Scan() {
+		total = addTasks(total, LineTasks(lineScanner.Text(), cfg))
+	}
+	if err := lineScanner.Err(); err != nil {
+		return 0, fmt.Errorf("读取CIDR文件失败: %v", err)
+	}
+	return total, nil
+}
+
+// 统计 CIDR 文件中一行展开后的任务数，IP 目标按 vhosts 展开的每个虚拟主机各算一次
+func LineTasks(line string, cfg *config.Config) int64 {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return 0
+	}
+
+	// ip:port 格式只扫描 urlPaths
+	if isIPPortFormat(line) {
+		if _, portStr, ok := parseIPPort(line); ok {
+			port, err := strconv.Atoi(portStr)
+			if err == nil && port > 0 && port <= 65535 {
+				return int64(len(cfg.URLPaths)) * util.VhostCount(cfg)
+			}
+		}
+	}
+
+	perIP := util.PerIPRequests(cfg)
+	if ip := net.ParseIP(line); ip != nil {
+		return mulTasks(hostCount(GetCIDRFromSingleIP(line)), perIP)
+	}
+	if _, _, err := net.ParseCIDR(line); err == nil {
+		return mulTasks(hostCount(line), perIP)
+	}
+	if ips := strings.Split(line, "-"); len(ips) == 2 && net.ParseIP(strings.TrimSpace(ips[0])) != nil && net.ParseIP(strings.TrimSpace(ips[1])) != nil {
+		cidrs, err := IPRangeToCIDRs(strings.TrimSpace(ips[0]), strings.TrimSpace(ips[1]))
+		if err != nil {
+			return 0
+		}
+		var hosts int64
+		for _, c := range cidrs {
+			hosts = addTasks(hosts, hostCount(c))
+		}
+		return mulTasks(hosts, perIP)
+	}
+	if hasLetter(line) {
+		// 域名按单个目标计算，不按 vhosts 展开
+		return util.PerIPTasks(cfg)
+	}
+	return 0
+}
+
+// 统计 CIDR 中实际扫描的主机数，IPv4 跳过主机部分为 0 或 255 的地址
+func hostCount(cidr string) int64 {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return 0
+	}
+	ones, bits := ipNet.Mask.Size()
+	hostBits := bits - ones
+	if hostBits >= 62 {
+		return math.MaxInt64
+	}
+	size := int64(1) << hostBits
+	if bits != 32 {
+		return size
+	}
+	if hostBits >= 8 {
+		// 每 256 个地址中有 .0 和 .255 两个被跳过
+		return size - size/256*2
+	}
+	var count int64
+	ip := ipNet.IP.To4()
+	for i := int64(0); i < size; i++ {
+		lastOctet := int64(ip[3]) + i
+		if lastOctet != 0 && lastOctet != 255 {
+			count++
+		}
+	}
+	return count
+}
+
+// 检查字符串是否包含字母
+func hasLetter(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// 防止溢出的加法
+func addTasks(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// 防止溢出的乘法
+func mulTasks(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if a > math.MaxInt64/b {
+		return math.MaxInt64
+	}
+	return a * b
+}
@@ -11,13 +11,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/qist/iptv-static-scan/checkpoint"
 	"github.com/qist/iptv-static-scan/config"
 	"github.com/qist/iptv-static-scan/domain"
+	"github.com/qist/iptv-static-scan/network"
+	"github.com/qist/iptv-static-scan/output"
 	"github.com/qist/iptv-static-scan/scanner"
+	"github.com/qist/iptv-static-scan/util"
 )
 
 // 解析CIDR文件并添加任务到 worker pool 处理
-func ParseCIDRFile(workerPool *scanner.WorkerPool, cfg *config.Config, successfulIPsCh chan<- string) error {
+func ParseCIDRFile(workerPool *scanner.WorkerPool, cfg *config.Config, successfulIPsCh chan<- *output.Result) error {
 	file, err := os.Open(cfg.CIDRFile)
 	if err != nil {
 		return fmt.Errorf("打开CIDR文件失败: %v", err)
@@ -27,9 +31,23 @@ func ParseCIDRFile(workerPool *scanner.WorkerPool, cfg *config.Config, successfu
 	scannerScanner := bufio.NewScanner(file)
 	sem := make(chan struct{}, cfg.MaxConcurrentRequest)
 	var wg sync.WaitGroup
+	cp := workerPool.Checkpoint
+	counter := workerPool.Progress
+	lineNum := 0
 	for scannerScanner.Scan() {
+		// 扫描中断后不再读取后续的行
+		if workerPool.Stopped() {
+			break
+		}
 		line := scannerScanner.Text()
 		line = strings.TrimSpace(line)
+		lineNum++
+		// 断点恢复时跳过已完成的行
+		if cp.SkipLine(lineNum) {
+			counter.Skip(LineTasks(line, cfg))
+			continue
+		}
+		base := checkpoint.Position{Line: lineNum}
 
 		// 检查是否为 ip:port 格式
 		if isIPPortFormat(line) {
@@ -38,14 +56,42 @@ func ParseCIDRFile(workerPool *scanner.WorkerPool, cfg *config.Config, successfu
 				// 验证端口格式
 				port, err := strconv.Atoi(portStr)
 				if err == nil && port > 0 && port <= 65535 {
-					// 是 ip:port 格式，直接添加任务到 worker pool
-					for _, urlPath := range cfg.URLPaths {
-						wg.Add(1)
-						sem <- struct{}{}
-						go func(port int, urlPath string) {
-							defer wg.Done()
-							defer func() { <-sem }()
+					// 断点单元必须按读取顺序创建，预扫描和下发任务放到协程中，端口未开放时不阻塞读取
+					var units []*checkpoint.Unit
+					var paths []string
+					for i, urlPath := range cfg.URLPaths {
+						pos := base
+						pos.TaskIndex = i
+						if cp.Skip(pos) {
+							counter.Skip(util.VhostCount(cfg))
+							continue
+						}
+						units = append(units, cp.Begin(pos))
+						paths = append(paths, urlPath)
+					}
+					if len(paths) == 0 {
+						continue
+					}
+					wg.Add(1)
+					sem <- struct{}{}
+					go func(line, ip string, port int) {
+						defer wg.Done()
+						defer func() { <-sem }()
+						defer func() {
+							for _, unit := range units {
+								unit.Seal()
+							}
+						}()
 
+						// 同一行的预扫描和请求使用同一个出口地址
+						srcAddr := network.NextSourceAddr()
+						// TCP 预扫描，端口未开放时跳过该行
+						if !scanner.PreScanPorts(ip, []int{port}, srcAddr, cfg).Open(port) {
+							counter.Skip(int64(len(paths)) * util.VhostCount(cfg))
+							return
+						}
+						// 是 ip:port 格式，直接添加任务到 worker pool
+						for i, urlPath := range paths {
 							// 获取当前时间戳，并截取前9位
 							timestamp := int(time.Now().Unix())
 							timestampStr := fmt.Sprintf("%d", timestamp)[:9]
@@ -64,9 +110,9 @@ func ParseCIDRFile(workerPool *scanner.WorkerPool, cfg *config.Config, successfu
 								urlPath = strings.Replace(urlPath, "{timeFirst}", timeFirst, -1)
 								urlPath = strings.Replace(urlPath, "{timestampMinus5}", strconv.Itoa(timestampMinus5), -1)
 							}
-							scanner.AddTaskToPool(workerPool, ip, port, urlPath, cfg, successfulIPsCh)
-						}(port, urlPath)
-					}
+							scanner.AddTaskToPool(workerPool, &network.Target{IP: ip, Port: port, Path: urlPath, Source: line, SourceAddr: srcAddr}, cfg, successfulIPsCh, units[i])
+						}
+					}(line, ip, port)
 					continue
 				}
 			}
@@ -76,7 +122,7 @@ func ParseCIDRFile(workerPool *scanner.WorkerPool, cfg *config.Config, successfu
 		switch domain.IsDomain(line) {
 		case 1:
 			// 是域名且能解析，直接处理
-			err := scanner.ProcessCIDR(workerPool, line, cfg, successfulIPsCh)
+			err := scanner.ProcessCIDR(workerPool, line, line, base, cfg, successfulIPsCh)
 			if err != nil {
 				log.Printf("处理域名失败: %v\n", err)
 			}
@@ -84,6 +130,7 @@ func ParseCIDRFile(workerPool *scanner.WorkerPool, cfg *config.Config, successfu
 		case 0:
 			// 是域名但不能解析，跳过
 			log.Printf("无法解析域名: %s\n", line)
+			counter.Skip(LineTasks(line, cfg))
 			continue
 
 		case 2:
@@ -101,8 +148,14 @@ func ParseCIDRFile(workerPool *scanner.WorkerPool, cfg *config.Config, successfu
 						continue
 					}
 					// 处理每个CIDR
-					for _, cidr := range cidrs {
-						err := scanner.ProcessCIDR(workerPool, cidr, cfg, successfulIPsCh)
+					for i, cidr := range cidrs {
+						if cp.SkipSub(lineNum, i) {
+							counter.Skip(mulTasks(hostCount(cidr), util.PerIPRequests(cfg)))
+							continue
+						}
+						pos := base
+						pos.Sub = i
+						err := scanner.ProcessCIDR(workerPool, cidr, line, pos, cfg, successfulIPsCh)
 						if err != nil {
 							log.Printf("处理CIDR失败: %v\n", err)
 						}
@@ -112,7 +165,7 @@ func ParseCIDRFile(workerPool *scanner.WorkerPool, cfg *config.Config, successfu
 				}
 			} else {
 				// 如果是CIDR格式，直接处理
-				err := scanner.ProcessCIDR(workerPool, line, cfg, successfulIPsCh)
+				err := scanner.ProcessCIDR(workerPool, line, line, base, cfg, successfulIPsCh)
 				if err != nil {
 					log.Printf("处理CIDR失败: %v\n", err)
 				}
@@ -1,9 +1,10 @@
 package config
 
 import (
+	_ "embed"
 	"os"
 	"strings"
-	_ "embed"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -20,12 +21,62 @@ type Config struct {
 	DownSize             float64             `yaml:"downSize"`
 	FileBufferSize       int                 `yaml:"filebufferSize"`
 	DownloadTS           bool                `yaml:"download_ts"`
+	HLSMaxVariants       int                 `yaml:"hlsMaxVariants"`
+	HLSSegments          int                 `yaml:"hlsSegments"`
+	HLSLiveness          bool                `yaml:"hlsLiveness"`
+	HLSLivenessMaxWait   int                 `yaml:"hlsLivenessMaxWait"`
+	HLSExcludeEncrypted  string              `yaml:"hlsExcludeEncrypted"`
+	Rules                yaml.Node           `yaml:"rules"` // 由 scanner.InitRules 按 rules.Rule 解析
+	DisableDefaultRules  bool                `yaml:"disableDefaultRules"`
+	Fingerprints         yaml.Node           `yaml:"fingerprints"` // 由 network.InitFingerprints 按 fingerprint.Fingerprint 解析
+	FingerprintFavicon   bool                `yaml:"fingerprintFavicon"`
+	TSValidate           bool                `yaml:"tsValidate"`
+	TSMaxErrorRate       float64             `yaml:"tsMaxErrorRate"`
+	FLVValidate          bool                `yaml:"flvValidate"`
+	MulticastPaths       []string            `yaml:"multicastPaths"`
+	MulticastFile        string              `yaml:"multicastFile"`
+	MulticastConcurrency int                 `yaml:"multicastConcurrency"`
+	UdpxyStatus          bool                `yaml:"udpxyStatus"`
 	Outputs              bool                `yaml:"outputs"`
+	OutputFormat         string              `yaml:"outputFormat"`
+	PlaylistFile         string              `yaml:"playlistFile"`
+	PlaylistGroupBy      string              `yaml:"playlistGroupBy"`
 	LogEnabled           bool                `yaml:"logEnabled"`
 	LogTimeFile          string              `yaml:"LogTimeFile"`
 	LogTime              int                 `yaml:"LogTime"`
 	LogIpEnabled         bool                `yaml:"LogIpEnabled"`
 	LogTimeEnabled       bool                `yaml:"LogTimeEnabled"`
+	RateLimit            float64             `yaml:"rateLimit"`
+	RateBurst            int                 `yaml:"rateBurst"`
+	ConnRateLimit        float64             `yaml:"connRateLimit"`
+	ConnRateBurst        int                 `yaml:"connRateBurst"`
+	PreScan              bool                `yaml:"preScan"`
+	PreScanTimeout       int                 `yaml:"preScanTimeout"`
+	PreScanConcurrency   int                 `yaml:"preScanConcurrency"`
+	HostConcurrency      int                 `yaml:"hostConcurrency"`
+	HostDelay            int                 `yaml:"hostDelay"`
+	Scheme               string              `yaml:"scheme"`
+	PortSchemes          map[string]string   `yaml:"portSchemes"`
+	PathSchemes          map[string]string   `yaml:"pathSchemes"`
+	HostHeader           string              `yaml:"hostHeader"`
+	Vhosts               []string            `yaml:"vhosts"`
+	TLSServerName        string              `yaml:"tlsServerName"`
+	SourceAddrs          []string            `yaml:"sourceAddrs"`
+	DNSServers           []string            `yaml:"dnsServers"`
+	ExpandDomains        bool                `yaml:"expandDomains"`
+	DNSTimeout           int                 `yaml:"dnsTimeout"`
+	DNSCacheTTL          int                 `yaml:"dnsCacheTTL"`
+	Proxies              []string            `yaml:"proxies"`
+	ProxyMaxFails        int                 `yaml:"proxyMaxFails"`
+	ProxyCooldown        int                 `yaml:"proxyCooldown"`
+	MaxIdleConns         int                 `yaml:"maxIdleConns"`
+	MaxIdleConnsPerHost  int                 `yaml:"maxIdleConnsPerHost"`
+	MaxConnsPerHost      int                 `yaml:"maxConnsPerHost"`
+	IdleConnTimeout      int                 `yaml:"idleConnTimeout"`
+	ProgressBar          bool                `yaml:"progressBar"`
+	ProgressInterval     int                 `yaml:"progressInterval"`
+	CheckpointFile       string              `yaml:"checkpointFile"`
+	CheckpointInterval   int                 `yaml:"checkpointInterval"`
 }
 
 func LoadConfig(filename string) (*Config, error) {
@@ -33,7 +84,11 @@ func LoadConfig(filename string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	var cfg Config
+	// 配置文件中没有的项保留这里的默认值
+	cfg := Config{
+		TSValidate:  true,
+		FLVValidate: true,
+	}
 	err = yaml.Unmarshal(data, &cfg)
 	if err != nil {
 		return nil, err
@@ -1,9 +1,12 @@
 package domain
 
 import (
+	"context"
 	"net"
 	"strings"
 	"unicode"
+
+	"github.com/qist/iptv-static-scan/resolver"
 )
 
 // 判断输入是否为域名
@@ -62,8 +65,8 @@ func containsLetter(s string) bool {
     return false
 }
 
-// 检查域名是否能解析
+// 检查域名是否能解析，解析结果会被缓存，后续连接不再重复解析
 func isResolvableDomain(domain string) bool {
-    _, err := net.LookupIP(domain)
+    _, err := resolver.LookupIP(context.Background(), domain)
     return err == nil
 }
\ No newline at end of file
@@ -0,0 +1,64 @@
+package fingerprint
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math/bits"
+)
+
+// 计算 favicon 哈希，与 Shodan 的 http.favicon.hash 相同：
+// 对每 76 个字符换行的 base64 编码（末尾也有换行）计算 32 位 MurmurHash3
+func FaviconHash(data []byte) int32 {
+	enc := base64.StdEncoding.EncodeToString(data)
+	buf := make([]byte, 0, len(enc)+len(enc)/76+1)
+	for len(enc) > 76 {
+		buf = append(buf, enc[:76]...)
+		buf = append(buf, '\n')
+		enc = enc[76:]
+	}
+	buf = append(buf, enc...)
+	buf = append(buf, '\n')
+	return int32(murmur3(buf, 0))
+}
+
+// MurmurHash3 x86_32
+func murmur3(data []byte, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	h := seed
+	n := len(data) / 4
+	for i := 0; i < n; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	tail := data[n*4:]
+	var k uint32
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
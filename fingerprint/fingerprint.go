@@ -0,0 +1,192 @@
+package fingerprint
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed db.yaml
+var builtin []byte
+
+var titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// 标题最多保留的字符数
+const maxTitleLen = 100
+
+// Fingerprint 一个产品的识别特征
+type Fingerprint struct {
+	Product string            `yaml:"product"`
+	Server  string            `yaml:"server"`  // Server 响应头正则
+	Headers map[string]string `yaml:"headers"` // 其他响应头正则
+	Title   string            `yaml:"title"`   // 网页标题正则
+	Body    string            `yaml:"body"`    // 响应体正则
+	Path    string            `yaml:"path"`    // URL 路径正则，其他特征都没有匹配时才使用
+	Favicon []int32           `yaml:"favicon"` // favicon.ico 的 mmh3 哈希
+
+	server  *regexp.Regexp
+	headers map[string]*regexp.Regexp
+	title   *regexp.Regexp
+	body    *regexp.Regexp
+	path    *regexp.Regexp
+}
+
+// Response 识别使用的响应
+type Response struct {
+	Path    string
+	Header  http.Header
+	Body    []byte               // 已读取的响应体，可以为空
+	Favicon func() (int32, bool) // 获取 favicon 哈希，为 nil 时不使用 favicon
+}
+
+// Match 识别结果
+type Match struct {
+	Product     string
+	Version     string
+	Title       string
+	FaviconHash *int32
+}
+
+// DB 编译后的指纹库
+type DB struct {
+	entries []*Fingerprint
+}
+
+// 编译指纹库，custom 排在内置指纹之前
+func New(custom []Fingerprint) (*DB, error) {
+	var entries []Fingerprint
+	if err := yaml.Unmarshal(builtin, &entries); err != nil {
+		return nil, fmt.Errorf("解析内置指纹失败: %v", err)
+	}
+	entries = append(append([]Fingerprint(nil), custom...), entries...)
+	db := &DB{}
+	for i := range entries {
+		f := &entries[i]
+		if f.Product == "" {
+			return nil, fmt.Errorf("第 %d 条指纹缺少 product", i+1)
+		}
+		if err := f.compile(); err != nil {
+			return nil, fmt.Errorf("指纹 %s: %v", f.Product, err)
+		}
+		db.entries = append(db.entries, f)
+	}
+	return db, nil
+}
+
+func (f *Fingerprint) compile() error {
+	var err error
+	compile := func(pattern string) *regexp.Regexp {
+		if pattern == "" || err != nil {
+			return nil
+		}
+		var re *regexp.Regexp
+		re, err = regexp.Compile(pattern)
+		return re
+	}
+	f.server = compile(f.Server)
+	f.title = compile(f.Title)
+	f.body = compile(f.Body)
+	f.path = compile(f.Path)
+	for k, v := range f.Headers {
+		if f.headers == nil {
+			f.headers = make(map[string]*regexp.Regexp)
+		}
+		f.headers[k] = compile(v)
+	}
+	return err
+}
+
+// 识别响应对应的产品，先按响应头、标题、响应体和 favicon 匹配，都没有匹配时再按 URL 路径匹配
+// 没有识别出产品且没有标题时返回 nil
+func (db *DB) Identify(resp *Response) *Match {
+	if db == nil {
+		return nil
+	}
+	m := &Match{Title: pageTitle(resp.Body)}
+	server := resp.Header.Get("Server")
+
+	var favicon *int32
+	if resp.Favicon != nil {
+		if h, ok := resp.Favicon(); ok {
+			favicon = &h
+			m.FaviconHash = favicon
+		}
+	}
+
+	for _, f := range db.entries {
+		if v, ok := f.matchStrong(server, resp, m.Title, favicon); ok {
+			m.Product, m.Version = f.Product, v
+			return m
+		}
+	}
+	for _, f := range db.entries {
+		if f.path != nil && f.path.MatchString(resp.Path) {
+			m.Product = f.Product
+			return m
+		}
+	}
+	if m.Title == "" && m.FaviconHash == nil {
+		return nil
+	}
+	return m
+}
+
+// 按响应头、标题、响应体和 favicon 匹配，返回版本号
+func (f *Fingerprint) matchStrong(server string, resp *Response, title string, favicon *int32) (string, bool) {
+	if v, ok := matchVersion(f.server, server); ok {
+		return v, true
+	}
+	for k, re := range f.headers {
+		if v, ok := matchVersion(re, resp.Header.Get(k)); ok {
+			return v, true
+		}
+	}
+	if v, ok := matchVersion(f.title, title); ok {
+		return v, true
+	}
+	if f.body != nil && len(resp.Body) > 0 {
+		if sm := f.body.FindSubmatch(resp.Body); sm != nil {
+			if len(sm) > 1 {
+				return strings.TrimSpace(string(sm[1])), true
+			}
+			return "", true
+		}
+	}
+	if favicon != nil && slices.Contains(f.Favicon, *favicon) {
+		return "", true
+	}
+	return "", false
+}
+
+// 正则匹配时返回第一个分组作为版本号
+func matchVersion(re *regexp.Regexp, s string) (string, bool) {
+	if re == nil || s == "" {
+		return "", false
+	}
+	sm := re.FindStringSubmatch(s)
+	if sm == nil {
+		return "", false
+	}
+	if len(sm) > 1 {
+		return strings.TrimSpace(sm[1]), true
+	}
+	return "", true
+}
+
+// 提取网页标题
+func pageTitle(body []byte) string {
+	sm := titleRe.FindSubmatch(body)
+	if sm == nil {
+		return ""
+	}
+	title := strings.Join(strings.Fields(string(sm[1])), " ")
+	if r := []rune(title); len(r) > maxTitleLen {
+		title = string(r[:maxTitleLen])
+	}
+	return title
+}
@@ -0,0 +1,176 @@
+package hls
+
+import (
+	"bufio"
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Playlist 解析后的 m3u8 播放列表
+type Playlist struct {
+	Master         bool      // 是否为包含 EXT-X-STREAM-INF 的主播放列表
+	Version        int       // EXT-X-VERSION
+	TargetDuration float64   // EXT-X-TARGETDURATION，单位秒
+	MediaSequence  int64     // EXT-X-MEDIA-SEQUENCE
+	EndList        bool      // 是否有 EXT-X-ENDLIST
+	Variants       []Variant // 主播放列表中的各码率子播放列表
+	Segments       []Segment // 媒体播放列表中的分片
+}
+
+// Variant 主播放列表中的一个码率
+type Variant struct {
+	URI              string
+	Bandwidth        int
+	AverageBandwidth int
+	Resolution       string
+	FrameRate        float64
+	Codecs           string
+}
+
+// Segment 媒体播放列表中的一个分片
+type Segment struct {
+	URI      string
+	Duration float64
+	Map      string // EXT-X-MAP 指定的初始化分片地址，fMP4 分片时有值
+	Key      *Key   // EXT-X-KEY 指定的加密方式，不加密时为 nil
+}
+
+// Key EXT-X-KEY 标签
+type Key struct {
+	Method    string // AES-128、SAMPLE-AES 等
+	URI       string
+	IV        string
+	KeyFormat string // 为空时表示 identity
+}
+
+// 解析 m3u8 内容，第一行不是 #EXTM3U 时返回错误
+func Parse(content string) (*Playlist, error) {
+	s := bufio.NewScanner(strings.NewReader(content))
+	s.Buffer(make([]byte, 0, 64<<10), 1<<20)
+
+	pl := &Playlist{}
+	header := false
+	var variant *Variant // 等待下一行地址的 EXT-X-STREAM-INF
+	var duration float64
+	var initMap string
+	var key *Key
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if !header {
+			if strings.TrimPrefix(line, "\ufeff") != "#EXTM3U" {
+				return nil, errors.New("缺少 #EXTM3U 头")
+			}
+			header = true
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			if variant != nil {
+				variant.URI = line
+				pl.Variants = append(pl.Variants, *variant)
+				variant = nil
+			} else {
+				pl.Segments = append(pl.Segments, Segment{URI: line, Duration: duration, Map: initMap, Key: key})
+			}
+			duration = 0
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXT-X-VERSION":
+			pl.Version, _ = strconv.Atoi(value)
+		case "#EXT-X-TARGETDURATION":
+			pl.TargetDuration, _ = strconv.ParseFloat(value, 64)
+		case "#EXT-X-MEDIA-SEQUENCE":
+			pl.MediaSequence, _ = strconv.ParseInt(value, 10, 64)
+		case "#EXT-X-ENDLIST":
+			pl.EndList = true
+		case "#EXTINF":
+			d, _, _ := strings.Cut(value, ",")
+			duration, _ = strconv.ParseFloat(strings.TrimSpace(d), 64)
+		case "#EXT-X-MAP":
+			initMap = ParseAttributes(value)["URI"]
+		case "#EXT-X-KEY":
+			attrs := ParseAttributes(value)
+			key = nil
+			if method := strings.ToUpper(attrs["METHOD"]); method != "" && method != "NONE" {
+				key = &Key{Method: method, URI: attrs["URI"], IV: attrs["IV"], KeyFormat: attrs["KEYFORMAT"]}
+			}
+		case "#EXT-X-STREAM-INF":
+			pl.Master = true
+			attrs := ParseAttributes(value)
+			v := Variant{
+				Resolution: attrs["RESOLUTION"],
+				Codecs:     attrs["CODECS"],
+			}
+			v.Bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+			v.AverageBandwidth, _ = strconv.Atoi(attrs["AVERAGE-BANDWIDTH"])
+			v.FrameRate, _ = strconv.ParseFloat(attrs["FRAME-RATE"], 64)
+			variant = &v
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, errors.New("缺少 #EXTM3U 头")
+	}
+	return pl, nil
+}
+
+// 解析 KEY=VALUE,KEY="VALUE" 形式的属性列表，带引号的值中可以包含逗号
+func ParseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for s != "" {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToUpper(strings.TrimSpace(key))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, s = rest[1:], ""
+			} else {
+				value, s = rest[1:end+1], rest[end+2:]
+			}
+			_, s, _ = strings.Cut(s, ",")
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+		}
+		attrs[key] = strings.TrimSpace(value)
+	}
+	return attrs
+}
+
+// 分片的封装格式，有 EXT-X-MAP 或扩展名为 m4s、mp4 时为 fmp4，否则为 ts
+func (p *Playlist) Container() string {
+	if len(p.Segments) == 0 {
+		return ""
+	}
+	seg := p.Segments[0]
+	if seg.Map != "" {
+		return "fmp4"
+	}
+	switch strings.ToLower(path.Ext(strings.SplitN(seg.URI, "?", 2)[0])) {
+	case ".m4s", ".mp4":
+		return "fmp4"
+	}
+	return "ts"
+}
+
+// 返回播放列表中第一个加密分片的密钥，全部不加密时返回 nil
+func (p *Playlist) Key() *Key {
+	for _, seg := range p.Segments {
+		if seg.Key != nil {
+			return seg.Key
+		}
+	}
+	return nil
+}
@@ -5,19 +5,29 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/qist/iptv-static-scan/checkpoint"
 	"github.com/qist/iptv-static-scan/cidr"
 	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/multicast"
+	"github.com/qist/iptv-static-scan/network"
 	"github.com/qist/iptv-static-scan/output"
+	"github.com/qist/iptv-static-scan/progress"
+	"github.com/qist/iptv-static-scan/resolver"
 	"github.com/qist/iptv-static-scan/scanner"
+	"github.com/qist/iptv-static-scan/util"
 )
 var VersionFlag *bool
 func main() {
 	// 使用flag包解析命令行参数
 	configFile := flag.String("config", "config.yaml", "配置文件的路径")
 	VersionFlag = flag.Bool("version", false, "显示版本号")
+	resumeFlag := flag.Bool("resume", false, "从断点文件继续上次未完成的扫描")
 	flag.Parse()
 
 	// 如果显示版本号，打印版本号并退出
@@ -36,25 +46,141 @@ func main() {
 		return
 	}
 
+	// 展开路径中的组播模板并加载 udpxy 组播扫描列表
+	if err := multicast.ExpandConfig(cfg); err != nil {
+		fmt.Println("展开组播路径失败:", err)
+		return
+	}
+	if err := scanner.InitMulticastSweep(cfg); err != nil {
+		fmt.Println("加载组播列表失败:", err)
+		return
+	}
+	if err := scanner.InitRules(cfg); err != nil {
+		fmt.Println("加载检测规则失败:", err)
+		return
+	}
+
 	// 设置日志记录器
 	if !cfg.LogEnabled {
 		log.SetOutput(io.Discard)
 	}
 
-	// 清空文件内容
-	err = output.ClearFileContent(cfg.SuccessfulIPsFile)
+	// 初始化全局限速
+	network.InitRateLimiter(cfg)
+
+	// 初始化域名解析器
+	dnsResolver, err := resolver.New(cfg.DNSServers, time.Duration(cfg.DNSTimeout)*time.Millisecond, time.Duration(cfg.DNSCacheTTL)*time.Second)
+	if err != nil {
+		fmt.Println("初始化域名解析失败:", err)
+		return
+	}
+	resolver.SetDefault(dnsResolver)
+
+	// 初始化本地出口地址
+	if err := network.InitSourceAddrs(cfg); err != nil {
+		fmt.Println("初始化出口地址失败:", err)
+		return
+	}
+
+	// 初始化上游代理
+	if err := network.InitProxy(cfg); err != nil {
+		fmt.Println("初始化代理失败:", err)
+		return
+	}
+
+	// 加载服务指纹库
+	if err := network.InitFingerprints(cfg); err != nil {
+		fmt.Println("加载指纹库失败:", err)
+		return
+	}
+
+	// 加载或创建断点记录
+	var cp *checkpoint.Tracker
+	if *resumeFlag {
+		if cfg.CheckpointFile == "" {
+			fmt.Println("未配置 checkpointFile，无法断点续扫")
+			return
+		}
+		cp, err = checkpoint.Load(cfg.CheckpointFile, cfg.CIDRFile)
+		if os.IsNotExist(err) {
+			fmt.Println("未找到断点文件，重新开始扫描:", cfg.CheckpointFile)
+			cp = nil
+		} else if err != nil {
+			fmt.Println("加载断点文件失败:", err)
+			return
+		}
+	}
+	if cp == nil && cfg.CheckpointFile != "" {
+		cp = checkpoint.New(cfg.CheckpointFile, cfg.CIDRFile)
+	}
+
+	// 断点续扫时保留已有结果，否则清空文件内容
+	if !cp.Resuming() {
+		err = output.ClearFileContent(cfg.SuccessfulIPsFile)
+		if err != nil {
+			log.Printf("清空文件内容失败: %v\n", err)
+			return
+		}
+		if cfg.PlaylistFile != "" {
+			err = output.ClearFileContent(cfg.PlaylistFile)
+			if err != nil {
+				log.Printf("清空播放列表文件失败: %v\n", err)
+				return
+			}
+		}
+	} else {
+		fmt.Println("从断点继续扫描:", cfg.CheckpointFile)
+	}
+
+	checkpointInterval := cfg.CheckpointInterval
+	if checkpointInterval <= 0 {
+		checkpointInterval = 30
+	}
+	cp.Start(time.Duration(checkpointInterval) * time.Second)
+
+	// 创建结果写入器
+	resultWriter, err := output.NewResultWriter(cfg)
 	if err != nil {
-		log.Printf("清空文件内容失败: %v\n", err)
+		fmt.Println("创建结果输出失败:", err)
 		return
 	}
 
-	successfulIPsCh := make(chan string, cfg.FileBufferSize)
+	// 统计任务总数并创建进度计数器
+	total, err := cidr.CountTasks(cfg)
+	if err != nil {
+		log.Printf("统计任务总数失败: %v\n", err)
+	}
+	counter := progress.NewCounter(total)
+
+	// 定时把扫描进度写入 LogTimeFile
+	var progressLogger *progress.Logger
+	if cfg.LogTimeEnabled && cfg.LogTime > 0 && cfg.LogTimeFile != "" {
+		progressLogger = progress.NewLogger(counter, cfg.LogTimeFile, time.Duration(cfg.LogTime)*time.Minute, cfg.LogIpEnabled)
+		progressLogger.Start()
+	}
+
+	// 终端进度条
+	var bar *progress.Bar
+	if cfg.ProgressBar {
+		interval := cfg.ProgressInterval
+		if interval <= 0 {
+			interval = 10
+		}
+		bar = progress.NewBar(counter, time.Duration(interval)*time.Second)
+		bar.Start()
+	}
+
+	successfulIPsCh := make(chan *output.Result, cfg.FileBufferSize)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for successfulIP := range successfulIPsCh {
-			err := output.AppendToFile(cfg.SuccessfulIPsFile, successfulIP)
+		for result := range successfulIPsCh {
+			counter.Hit()
+			bar.Wrap(func() {
+				util.PrintSuccessURL(result.URL, result.Vhost, result.Fingerprint.Name(), result.SourceAddr, result.Server, cfg, result.Latency, result.Speed)
+			})
+			err := resultWriter.Write(result)
 			if err != nil {
 				log.Printf("写入成功的IP到文件失败: %v\n", err)
 			}
@@ -64,30 +190,72 @@ func main() {
 	BufferSize := cfg.MaxConcurrentRequest * 1024
 	// 创建并启动 worker pool
 	workerPool := scanner.NewWorkerPool(cfg.MaxConcurrentRequest, BufferSize)
+	workerPool.Checkpoint = cp
+	workerPool.Progress = counter
+	workerPool.SetHostPoliteness(cfg.HostConcurrency, time.Duration(cfg.HostDelay)*time.Millisecond)
 	workerPool.Start()
 
+	// 收到中断信号时停止下发任务，等待正在执行的任务完成、结果全部写入后再保存断点
+	// 任务完成即可能提交断点，结果未写入就退出会使 -resume 跳过这些命中
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		fmt.Println("收到中断信号，等待正在执行的任务完成后保存断点，再次中断立即退出")
+		workerPool.Stop()
+		<-sigCh
+		os.Exit(1)
+	}()
+
 	// 解析 CIDR 文件并直接添加任务到 worker pool
 	err = cidr.ParseCIDRFile(workerPool, cfg, successfulIPsCh)
 	if err != nil {
 		log.Printf("解析CIDR文件失败: %v\n", err)
+		resultWriter.Close()
 		return
 	}
 
-	// Task 向管道写入完关闭管道
-	close(workerPool.TaskQueue)
+	// 所有任务（包括组播扫描等执行中追加的任务）下发完后关闭管道
+	workerPool.Close()
 	// // 等待所有任务完成
 	workerPool.Wait()
 	// 关闭成功 IP 通道
 	close(successfulIPsCh)
 	wg.Wait()
+	// 结果全部写入后才保存断点
+	if err := resultWriter.Close(); err != nil {
+		log.Printf("关闭结果文件失败: %v\n", err)
+	}
+	network.CloseIdleConnections()
+	bar.Stop()
+	if progressLogger != nil {
+		progressLogger.Stop()
+	}
 	// 删除所有以 "stream9527_" 开头的文件
 	err = output.DeleteStreamFiles()
 	if err != nil {
 		log.Fatalf("删除文件失败: %v", err)
 	}
+	// 扫描中断，保存断点后退出
+	if workerPool.Stopped() {
+		if err := cp.Close(false); err != nil {
+			fmt.Println("保存断点文件失败:", err)
+		}
+		if cfg.CheckpointFile != "" {
+			fmt.Println("扫描已中断，断点已保存:", cfg.CheckpointFile)
+		}
+		os.Exit(1)
+	}
+	// 扫描全部完成，删除断点文件
+	if err := cp.Close(true); err != nil {
+		log.Printf("删除断点文件失败: %v\n", err)
+	}
 
 	elapsed := time.Since(start) // 计算并获取已用时间
 	fmt.Println("总扫描时间: ", elapsed)
 	fmt.Println("扫描结束: ", time.Now().Format("2006-01-02 15:04:05"))
 	fmt.Println("扫描完成请看文件:", cfg.SuccessfulIPsFile)
+	if cfg.PlaylistFile != "" {
+		fmt.Println("播放列表文件:", cfg.PlaylistFile)
+	}
 }
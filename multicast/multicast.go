@@ -0,0 +1,182 @@
+package multicast
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/qist/iptv-static-scan/config"
+)
+
+// 单个模板最多展开的组播地址数，防止误写成很大的范围
+const maxGroups = 65536
+
+// 路径模板中的组播范围，例如 {mcast:239.77.0.1-239.77.0.254} 或 {mcast:239.77.0.0/24}
+var templateRe = regexp.MustCompile(`\{mcast:([^}]+)\}`)
+
+// 组播列表文件中省略 rtp/、udp/ 前缀的 组播地址:端口
+var groupPortRe = regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+:\d+$`)
+
+// 展开路径中的组播模板，没有模板时原样返回
+// 只展开第一个模板，模板中的地址必须都是 IPv4 组播地址
+func Expand(path string) ([]string, error) {
+	loc := templateRe.FindStringSubmatchIndex(path)
+	if loc == nil {
+		return []string{path}, nil
+	}
+	groups, err := parseRange(path[loc[2]:loc[3]])
+	if err != nil {
+		return nil, fmt.Errorf("路径 %s 中的组播范围无效: %v", path, err)
+	}
+	paths := make([]string, 0, len(groups))
+	for _, group := range groups {
+		paths = append(paths, path[:loc[0]]+group+path[loc[1]:])
+	}
+	return paths, nil
+}
+
+// 解析 起始-结束 或 CIDR 格式的组播范围
+func parseRange(r string) ([]string, error) {
+	r = strings.TrimSpace(r)
+	var start, end uint32
+	if _, ipNet, err := net.ParseCIDR(r); err == nil {
+		ip4 := ipNet.IP.To4()
+		if ip4 == nil {
+			return nil, fmt.Errorf("只支持 IPv4 组播: %s", r)
+		}
+		ones, _ := ipNet.Mask.Size()
+		start = binary.BigEndian.Uint32(ip4)
+		end = start | (1<<(32-ones) - 1)
+	} else {
+		parts := strings.SplitN(r, "-", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("格式应为 起始地址-结束地址 或 CIDR: %s", r)
+		}
+		startIP := net.ParseIP(strings.TrimSpace(parts[0])).To4()
+		endIP := net.ParseIP(strings.TrimSpace(parts[1])).To4()
+		if startIP == nil || endIP == nil {
+			return nil, fmt.Errorf("只支持 IPv4 组播: %s", r)
+		}
+		start = binary.BigEndian.Uint32(startIP)
+		end = binary.BigEndian.Uint32(endIP)
+	}
+	if start > end {
+		return nil, fmt.Errorf("起始地址大于结束地址: %s", r)
+	}
+	if end-start >= maxGroups {
+		return nil, fmt.Errorf("范围超过 %d 个地址: %s", maxGroups, r)
+	}
+	groups := make([]string, 0, end-start+1)
+	for n := start; ; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, n)
+		if !ip.IsMulticast() {
+			return nil, fmt.Errorf("%s 不是组播地址", ip)
+		}
+		groups = append(groups, ip.String())
+		if n == end {
+			break
+		}
+	}
+	return groups, nil
+}
+
+// 展开 urlPaths 和 non_ports_path 中的组播模板，加载配置后调用
+func ExpandConfig(cfg *config.Config) error {
+	var urlPaths []string
+	for _, p := range cfg.URLPaths {
+		paths, err := Expand(p)
+		if err != nil {
+			return err
+		}
+		urlPaths = append(urlPaths, paths...)
+	}
+	cfg.URLPaths = urlPaths
+
+	var nonPortsPath []string
+	for _, p := range cfg.NonPortsPath {
+		paths, err := Expand(p)
+		if err != nil {
+			return err
+		}
+		nonPortsPath = append(nonPortsPath, paths...)
+	}
+	cfg.NonPortsPath = nonPortsPath
+	return nil
+}
+
+// 返回发现 udpxy 后要逐个探测的组播路径：multicastPaths 展开后加上 multicastFile 中的路径，去重后保持顺序
+func SweepPaths(cfg *config.Config) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(list []string) {
+		for _, p := range list {
+			p = strings.TrimPrefix(p, "/")
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	for _, p := range cfg.MulticastPaths {
+		expanded, err := Expand(p)
+		if err != nil {
+			return nil, err
+		}
+		add(expanded)
+	}
+	if cfg.MulticastFile != "" {
+		list, err := LoadFile(cfg.MulticastFile)
+		if err != nil {
+			return nil, err
+		}
+		add(list)
+	}
+	return paths, nil
+}
+
+// 读取组播列表文件，每行一个路径，支持：
+//
+//	rtp/239.3.1.129:8008
+//	udp/239.77.0.166:5146
+//	239.3.1.129:8008            省略前缀时按 rtp/ 处理
+//	CCTV1,rtp/239.3.1.129:8008  频道名,路径 格式取逗号后的路径
+//	rtp/{mcast:239.77.0.1-239.77.0.254}:5146
+//
+// 空行和 # 开头的行忽略
+func LoadFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("打开组播列表文件失败: %v", err)
+	}
+	defer f.Close()
+
+	var paths []string
+	lineScanner := bufio.NewScanner(f)
+	for lineScanner.Scan() {
+		line := strings.TrimSpace(lineScanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.LastIndex(line, ","); i >= 0 {
+			line = strings.TrimSpace(line[i+1:])
+		}
+		line = strings.TrimPrefix(line, "/")
+		if groupPortRe.MatchString(line) {
+			line = "rtp/" + line
+		}
+		expanded, err := Expand(line)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, expanded...)
+	}
+	if err := lineScanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取组播列表文件失败: %v", err)
+	}
+	return paths, nil
+}
@@ -1,131 +1,100 @@
 package network
 
 import (
-	"fmt"
-	"io"
+	"bytes"
 	"log"
-	"net/http"
 	"strings"
-	"time"
 
 	"github.com/qist/iptv-static-scan/config"
-	"github.com/qist/iptv-static-scan/util"
+	"github.com/qist/iptv-static-scan/hls"
+	"github.com/qist/iptv-static-scan/output"
 )
 
-// 检查MPEGURL内容
-func CheckMPEGURLContent(ip string, port int, urlPath string, cfg *config.Config, successfulIPsCh chan<- string) {
-	url := fmt.Sprintf("http://%s:%d/%s", ip, port, urlPath)
+// 检查MPEGURL内容，直接使用探测请求的响应体
+func CheckMPEGURLContent(p *Probe, cfg *config.Config, successfulIPsCh chan<- *output.Result) {
+	url := p.URL
+	duration := p.Latency
 
-	log.Printf("检查 %s 内容是否包含 'EXT-X-VERSION' 或者 'EXT-X-STREAM-INF'\n", url)
+	log.Printf("检查 %s 的 m3u8 播放列表\n", url)
 
-	client := CreateHTTPClient(cfg)    // 复用创建HTTP客户端的代码
-	req, err := CreateHTTPRequest(url) // 复用创建HTTP请求的代码
+	body, err := p.ReadText()
 	if err != nil {
-		log.Printf("创建请求失败: %v\n", err)
+		log.Printf("读取 %s 响应体失败: %v\n", url, err)
 		return
 	}
-	req.Header = cfg.UAHeaders // 设置请求头
 
-	start := time.Now()
-	resp, err := client.Do(req)
-	duration := time.Since(start)
+	pl, err := hls.Parse(string(body))
 	if err != nil {
-		log.Printf("请求 %s 失败: %v\n", url, err)
+		log.Printf("%s 不是有效的 m3u8: %v\n", url, err)
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		serverHeader := resp.Header.Get("Server")
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("读取 %s 响应体失败: %v\n", url, err)
-			return
-		}
-
-		m3u8Content := string(body)
-		containsVersion := strings.Contains(m3u8Content, "EXT-X-VERSION")
-		containsStream := strings.Contains(m3u8Content, "EXT-X-STREAM-INF")
-		containsDefaultVhost := strings.Contains(m3u8Content, "_defaultVhost_")
-		containsSegments := strings.Contains(m3u8Content, "EXT-X-INDEPENDENT-SEGMENTS")
-		containsExtInf := strings.Contains(m3u8Content, "EXTINF")
-		containsHttp := strings.Contains(m3u8Content, "http://")
-		containsMk := strings.Contains(m3u8Content, `"Ret":20102,"Reason":"`)
-		if (containsVersion && containsStream && !containsSegments) || (containsStream && containsDefaultVhost) || (containsStream && containsHttp) {
-			log.Printf("访问 %s 成功, 包含 'EXT-X-VERSION' 和 'EXT-X-STREAM-INF _defaultVhost_'，不写入文件, 耗时: %v\n", url, duration)
-		} else if cfg.DownloadTS && !containsStream && !containsMk {
-			DownloadTS(ip, port, urlPath, cfg, successfulIPsCh)
-		} else if (containsVersion && containsExtInf) || containsStream || containsMk || (containsVersion && containsSegments) {
-			log.Printf("访问 %s 成功, 包含 'EXT-X-VERSION' 或 'EXT-X-STREAM-INF' 或 '秒开', 耗时: %v\n", url, duration)
-			outputString := ""
-			outputString = util.GenerateOutputString(ip, port, urlPath, serverHeader, cfg, duration, nil)
-			// 去除输出字符串的首尾空白字符
-			trimmedOutput := strings.TrimSpace(outputString)
-			// 在写入文件之前检查去除空白后的字符串是否为空
-			if trimmedOutput != "" {
-				successfulIPsCh <- trimmedOutput
-			}
-		}
-	} else {
-		log.Printf("请求 %s 失败, 状态码: %d, 耗时: %v\n", url, resp.StatusCode, duration)
-		return // 状态码不为200时直接返回
+	// 带 _defaultVhost_ 或绝对地址的主播放列表多为转发到其他服务器的入口，不写入文件
+	if pl.Master && (bytes.Contains(body, []byte("_defaultVhost_")) || bytes.Contains(body, []byte("http://"))) {
+		log.Printf("访问 %s 成功, 主播放列表包含 '_defaultVhost_' 或绝对地址，不写入文件, 耗时: %v\n", url, duration)
+		return
 	}
-}
-
-func MkHTMLContent(ip string, port int, urlPath string, cfg *config.Config, successfulIPsCh chan<- string) {
-	url := fmt.Sprintf("http://%s:%d/%s", ip, port, urlPath)
-
-	log.Printf("检查 %s 内容是否包含 'window.PAGE_PREFIX = \"player-\"' 或 'window.PAGE_JS = \"mylive.html.js\"'\n", url)
-
-	client := CreateHTTPClient(cfg)    // 复用创建HTTP客户端的代码
-	req, err := CreateHTTPRequest(url) // 复用创建HTTP请求的代码
+	// 主播放列表跟随到各码率的子播放列表，download_ts 为 true 时下载分片
+	w := &hlsWalker{cfg: cfg}
+	info, err := w.walk(p.Target, pl, p.Start, 0)
 	if err != nil {
-		log.Printf("创建请求失败: %v\n", err)
+		log.Printf("%s 播放列表检查未通过: %v\n", url, err)
 		return
 	}
-	req.Header = cfg.UAHeaders // 设置请求头
-
-	start := time.Now()
-	resp, err := client.Do(req)
-	duration := time.Since(start)
-	if err != nil {
-		log.Printf("请求 %s 失败: %v\n", url, err)
+	if excludeEncrypted(info.Encryption, cfg) {
+		log.Printf("%s 的分片加密（%s），按 hlsExcludeEncrypted 配置不写入文件\n", url, info.Encryption)
 		return
 	}
-	defer resp.Body.Close()
+	result := newResult(p, output.KindM3U8, duration, nil, int64(len(body)))
+	if w.first != nil {
+		// 下载分片时使用分片的耗时和速度
+		result.Latency = w.first.Latency
+		result.Speed = w.first.Speed
+		result.BytesRead = w.first.BytesRead
+		result.Stream = w.first.Stream
+	}
+	info.Liveness = w.liveness
+	result.HLS = info
+	result.Fingerprint = identify(p, cfg)
+	log.Printf("访问 %s 成功, 播放列表检查通过, 耗时: %v\n", url, result.Latency)
+	successfulIPsCh <- result
+}
+
+// 直接将探测的响应作为命中结果，用于检测规则的 accept 动作
+func Accept(p *Probe, kind string, cfg *config.Config, successfulIPsCh chan<- *output.Result) {
+	// 网页读取完整内容，用于识别标题
+	if strings.Contains(p.Resp.Header.Get("Content-Type"), "html") {
+		p.ReadText()
+	}
+	result := newResult(p, kind, p.Latency, nil, int64(len(p.prefix)))
+	result.Fingerprint = identify(p, cfg)
+	successfulIPsCh <- result
+}
 
-	if resp.StatusCode == http.StatusOK {
-		serverHeader := resp.Header.Get("Server")
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("读取 %s 响应体失败: %v\n", url, err)
-			return
-		}
+// 请求响应体中提取的地址，相对地址基于探测目标的地址解析，用于检测规则的 follow-link 动作
+func FetchLink(t *Target, link string, cfg *config.Config) (*Probe, error) {
+	lt, err := resolveTarget(t, link)
+	if err != nil {
+		return nil, err
+	}
+	lt.Label = t.Label
+	return Fetch(lt, cfg)
+}
 
-		pageContent := string(body)
-		containsPagePrefix := strings.Contains(pageContent, `window.PAGE_PREFIX = "player-"`)
-		containsPageJS := strings.Contains(pageContent, `window.PAGE_JS = "mylive.html.js"`)
-		containsRet := strings.Contains(pageContent, `"Ret":`)
-		containsReason := strings.Contains(pageContent, `"Reason":`)
-		containsExtInf := strings.Contains(pageContent, "EXTINF")
-		containsVersion := strings.Contains(pageContent, "EXT-X-VERSION")
-		// containsJson := strings.Contains(pageContent, `CCTV`)
-		// containscore := strings.Contains(pageContent, `"code":401`)
-		if (containsPagePrefix && containsPageJS) || (containsRet && containsReason) || (containsExtInf && containsVersion) {
-			log.Printf("访问 %s 成功, 包含 'window.PAGE_PREFIX = \"player-\"' 和 'window.PAGE_JS = \"mylive.html.js\"', 耗时: %v\n", url, duration)
-			outputString := ""
-			outputString = util.GenerateOutputString(ip, port, urlPath, serverHeader, cfg, duration, nil)
-			// 去除输出字符串的首尾空白字符
-			trimmedOutput := strings.TrimSpace(outputString)
-			// 在写入文件之前检查去除空白后的字符串是否为空
-			if trimmedOutput != "" {
-				successfulIPsCh <- trimmedOutput
-			}
-		} else if containsPagePrefix || containsPageJS || containsReason || containsRet {
-			log.Printf("访问 %s 成功, 包含 'window.PAGE_PREFIX = \"player-\"' 或 'window.PAGE_JS = \"mylive.html.js\"'，不写入文件\n", url)
-		}
-	} else {
-		log.Printf("请求 %s 失败, 状态码: %d, 耗时: %v\n", url, resp.StatusCode, duration)
-		return // 状态码不为200时直接返回
+// 响应没有 Content-Type 时根据响应体前缀推断类型，无法判断时返回空字符串
+func SniffContentType(p *Probe) string {
+	prefix, _ := p.Peek(sniffSize)
+	trimmed := bytes.TrimSpace(prefix)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("#EXTM3U")):
+		return "application/vnd.apple.mpegurl"
+	case bytes.HasPrefix(prefix, []byte("FLV")):
+		return "video/x-flv"
+	case len(prefix) > 188 && prefix[0] == 0x47 && prefix[188] == 0x47:
+		return "video/mp2t"
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return "text/html"
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return "application/json"
 	}
+	return ""
 }
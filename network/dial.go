@@ -0,0 +1,43 @@
+package network
+
+import (
+	"context"
+	"net"
+
+	"github.com/qist/iptv-static-scan/proxy"
+	"github.com/qist/iptv-static-scan/resolver"
+)
+
+// 返回建立 TCP 连接的拨号函数，HTTP 请求和 TCP 预扫描共用
+// 配置了代理时先连接代理，由代理连接目标；连接代理或直连目标时用解析器解析域名，并绑定出口地址
+func newDialer(src *sourceAddr, d *net.Dialer) proxy.DialFunc {
+	return proxyPool.DialContext(resolvingDial(src.dialContext(d)))
+}
+
+// 用解析器解析地址中的域名，依次连接解析出的 IP，直到有一个连接成功
+func resolvingDial(dial proxy.DialFunc) proxy.DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		ips, err := resolver.LookupIP(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var firstErr error
+		for _, ip := range ips {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, firstErr
+	}
+}
@@ -11,147 +11,188 @@ import (
 	"time"
 
 	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/output"
 	"github.com/qist/iptv-static-scan/util"
 )
 
-// 下载流媒体文件
-func DownloadStream(ip string, port int, urlPath string, cfg *config.Config, successfulIPsCh chan<- string) {
-	var DownSize = int(float64(cfg.DownSize) * 1024 * 1024)
-	url := fmt.Sprintf("http://%s:%d/%s", ip, port, urlPath)
-	log.Printf("开始下载 http://%s:%d/%s\n", ip, port, urlPath)
-	client := CreateHTTPClient(cfg)    // 复用创建HTTP客户端的代码
-	req, err := CreateHTTPRequest(url) // 复用创建HTTP请求的代码
-	if err != nil {
-		log.Printf("创建请求失败: %v\n", err)
+// 请求目标并下载流媒体文件，用于组播列表中的频道等需要单独请求的地址
+func DownloadTarget(t *Target, kind string, cfg *config.Config, successfulIPsCh chan<- *output.Result) {
+	p := fetchStream(t, cfg)
+	if p == nil {
 		return
 	}
-	req.Header = cfg.UAHeaders // 设置请求头
+	defer p.Close()
+	if result := downloadStream(p, kind, cfg); result != nil {
+		result.Fingerprint = identify(p, cfg)
+		successfulIPsCh <- result
+	}
+}
 
-	start := time.Now()
-	resp, err := client.Do(req)
+// 请求目标并下载流媒体文件，下载失败或数据不合格时返回 nil，不识别服务软件，用于 m3u8 中的分片
+func downloadTarget(t *Target, kind string, cfg *config.Config) *output.Result {
+	p := fetchStream(t, cfg)
+	if p == nil {
+		return nil
+	}
+	defer p.Close()
+	return downloadStream(p, kind, cfg)
+}
+
+// 请求流媒体地址，请求失败或状态码不是 200 时返回 nil
+func fetchStream(t *Target, cfg *config.Config) *Probe {
+	log.Printf("开始下载 %s\n", t.URL())
+	p, err := Fetch(t, cfg)
 	if err != nil {
-		log.Printf("下载 http://%s:%d/%s 失败: %v\n", ip, port, urlPath, err)
-		return
+		log.Printf("下载 %s 失败: %v\n", t.URL(), err)
+		return nil
+	}
+	if p.Resp.StatusCode != http.StatusOK {
+		log.Printf("下载 %s 失败: 状态码 %d\n", p.URL, p.Resp.StatusCode)
+		p.Close()
+		return nil
 	}
-	defer resp.Body.Close()
+	return p
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("下载 http://%s:%d/%s 失败: 状态码 %d\n", ip, port, urlPath, resp.StatusCode)
-		return
+// 下载流媒体文件，直接读取探测请求的响应体，不再重复请求
+func DownloadStream(p *Probe, kind string, cfg *config.Config, successfulIPsCh chan<- *output.Result) {
+	if result := downloadStream(p, kind, cfg); result != nil {
+		result.Fingerprint = identify(p, cfg)
+		successfulIPsCh <- result
 	}
+}
+
+// 下载流媒体文件并生成命中结果，下载失败或数据不合格时返回 nil
+func downloadStream(p *Probe, kind string, cfg *config.Config) *output.Result {
+	var DownSize = int(float64(cfg.DownSize) * 1024 * 1024)
+	t := p.Target
+	url := p.URL
+	log.Printf("开始读取 %s 的流媒体数据\n", url)
 
-	serverHeader := resp.Header.Get("Server")
 	fileSize := 0
 
-	ippath := strings.ReplaceAll(ip, ".", "_")
+	ippath := strings.ReplaceAll(t.IP, ".", "_")
 	ippath = strings.ReplaceAll(ippath, ":", "_")
-	filename := util.GenerateFilename(urlPath)
+	filename := util.GenerateFilename(t.Path)
 	filename = strings.Trim(filename, "_")
-	file, err := os.Create(fmt.Sprintf("stream9527_%s_%d_%s", ippath, port, filename))
+	file, err := os.Create(fmt.Sprintf("stream9527_%s_%d_%s", ippath, t.Port, filename))
 	if err != nil {
 		log.Printf("创建文件失败: %v\n", err)
-		return
+		return nil
 	}
 	defer file.Close()
 
+	// TS 和 FLV 流边下载边解析
+	var ts *TSValidator
+	var flv *FLVParser
+	if kind == output.KindFLV {
+		flv = NewFLVParser()
+	} else if expectTS(p, kind) {
+		ts = NewTSValidator()
+	}
+
+	body := p.Body()
+	chunk := make([]byte, DownSize)
 	for {
-		chunk := make([]byte, DownSize)
-		n, err := resp.Body.Read(chunk)
+		n, err := body.Read(chunk)
 		if err != nil && err != io.EOF {
 			log.Printf("读取响应体失败: %v\n", err)
-			os.Remove(fmt.Sprintf("stream9527_%s_%d_%s", ippath, port, filename))
-			log.Printf("读取响应体失败 删除文件 stream9527_%s_%d_%s\n", ippath, port, filename)
-			return
+			os.Remove(fmt.Sprintf("stream9527_%s_%d_%s", ippath, t.Port, filename))
+			log.Printf("读取响应体失败 删除文件 stream9527_%s_%d_%s\n", ippath, t.Port, filename)
+			return nil
 		}
 		if n == 0 {
 			break
 		}
 		if _, err := file.Write(chunk[:n]); err != nil {
 			log.Printf("写入文件失败: %v\n", err)
-			os.Remove(fmt.Sprintf("stream9527_%s_%d_%s", ippath, port, filename))
-			log.Printf("写入文件失败 删除文件 stream9527_%s_%d_%s\n", ippath, port, filename)
-			return
+			os.Remove(fmt.Sprintf("stream9527_%s_%d_%s", ippath, t.Port, filename))
+			log.Printf("写入文件失败 删除文件 stream9527_%s_%d_%s\n", ippath, t.Port, filename)
+			return nil
+		}
+		if ts != nil {
+			ts.Write(chunk[:n])
+		}
+		if flv != nil {
+			flv.Write(chunk[:n])
 		}
 		fileSize += n
 		if fileSize >= DownSize {
 			break
 		}
 	}
-	duration := time.Since(start)                                 // 下载耗时
+	duration := time.Since(p.Start)                               // 下载耗时，包含建立连接和等待响应头
 	speed := float64(fileSize) / 1024 / 1024 / duration.Seconds() // MB/s
-	if fileSize >= DownSize {
-		log.Printf("下载完成 http://%s:%d/%s, 耗时: %v, 速度: %.2f MB/s\n", ip, port, urlPath, duration, speed)
-		os.Remove(fmt.Sprintf("stream9527_%s_%d_%s", ippath, port, filename))
-		log.Printf("删除文件 stream9527_%s_%d_%s\n", ippath, port, filename)
-		outputString := ""
-		outputString = util.GenerateOutputString(ip, port, urlPath, serverHeader, cfg, duration, &speed)
-		// 去除输出字符串的首尾空白字符
-		trimmedOutput := strings.TrimSpace(outputString)
-		// 在写入文件之前检查去除空白后的字符串是否为空
-		if trimmedOutput != "" {
-			successfulIPsCh <- trimmedOutput
+	if fileSize < DownSize {
+		os.Remove(fmt.Sprintf("stream9527_%s_%d_%s", ippath, t.Port, filename))
+		log.Printf("删除 文件大小未达到%.1fMB stream9527_%s_%d_%s\n", cfg.DownSize, ippath, t.Port, filename)
+		log.Printf("下载 %s 的流媒体文件成功, 但文件大小未达到%.1fMB\n", url, cfg.DownSize)
+		return nil
+	}
+	log.Printf("下载完成 %s, 耗时: %v, 速度: %.2f MB/s\n", url, duration, speed)
+	os.Remove(fmt.Sprintf("stream9527_%s_%d_%s", ippath, t.Port, filename))
+	log.Printf("删除文件 stream9527_%s_%d_%s\n", ippath, t.Port, filename)
+	result := newResult(p, kind, duration, &speed, int64(fileSize))
+	if ts != nil {
+		if err := ts.Check(cfg.TSMaxErrorRate); err != nil {
+			log.Printf("%s TS 校验未通过: %v\n", url, err)
+			if cfg.TSValidate {
+				return nil
+			}
 		}
-	} else {
-		os.Remove(fmt.Sprintf("stream9527_%s_%d_%s", ippath, port, filename))
-		log.Printf("删除 文件大小未达到%.1fMB stream9527_%s_%d_%s\n", cfg.DownSize, ippath, port, filename)
-		log.Printf("下载 http://%s:%d/%s 的流媒体文件成功, 但文件大小未达到%.1fMB\n", ip, port, urlPath, cfg.DownSize)
+		result.Stream = ts.Info()
 	}
-}
-
-func DownloadTS(ip string, port int, urlPath string, cfg *config.Config, successfulIPsCh chan<- string) {
-	url := fmt.Sprintf("http://%s:%d/%s", ip, port, urlPath)
-
-	log.Printf("检查 %s 内容是否包含可下载ts文件\n", url)
-
-	client := CreateHTTPClient(cfg)    // 复用创建HTTP客户端的代码
-	req, err := CreateHTTPRequest(url) // 复用创建HTTP请求的代码
-	if err != nil {
-		log.Printf("创建请求失败: %v\n", err)
-		return
+	if flv != nil {
+		if err := flv.Check(); err != nil {
+			log.Printf("%s FLV 校验未通过: %v\n", url, err)
+			if cfg.FLVValidate {
+				return nil
+			}
+		}
+		result.Stream = flv.Info()
 	}
-	req.Header = cfg.UAHeaders // 设置请求头
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("请求 %s 失败: %v\n", url, err)
-		return
+	if kind == output.KindUdpxy {
+		// 先断开流媒体连接，状态页中的客户端数不包含本次请求
+		p.Close()
+		result.Udpxy = UdpxyStatus(t, result.Server, cfg)
 	}
-	defer resp.Body.Close()
+	return result
+}
 
-	if resp.StatusCode == http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("读取 %s 响应体失败: %v\n", url, err)
-			return
+// 判断下载的数据是否应为 MPEG-TS：udpxy、m3u8 中的 ts 分片、Content-Type 为 mp2t 或以同步字节开头的流
+func expectTS(p *Probe, kind string) bool {
+	switch kind {
+	case output.KindUdpxy:
+		return true
+	case output.KindFLV:
+		return false
+	case output.KindM3U8:
+		if p.Target.encrypted {
+			return false
 		}
-
-		m3u8Content := string(body)
-		// 获取第一个.ts文件的URL
-		tsFile := GetFirstTSFile(m3u8Content)
-		if tsFile != "" {
-			baseURL := path.Dir(urlPath)
-			tsURLPath := fmt.Sprintf("%s/%s", baseURL, tsFile)
-			tsURLPath = strings.ReplaceAll(tsURLPath, "./", "")
-			DownloadStream(ip, port, tsURLPath, cfg, successfulIPsCh)
-		} else {
-			log.Printf("未找到 .ts 文件")
+		// fMP4 分片的扩展名不一定是 m4s，同时按开头的 box 类型判断
+		ext := strings.ToLower(path.Ext(strings.SplitN(p.Target.Path, "?", 2)[0]))
+		if ext == ".m4s" || ext == ".mp4" {
+			return false
 		}
-	} else {
-		log.Printf("请求 %s 失败, 状态码: %d\n", url, resp.StatusCode)
-		return // 状态码不为200时直接返回
+		prefix, _ := p.Peek(8)
+		return !isMP4Box(prefix)
 	}
+	if strings.Contains(p.Resp.Header.Get("Content-Type"), "mp2t") {
+		return true
+	}
+	prefix, _ := p.Peek(1)
+	return len(prefix) > 0 && prefix[0] == tsSyncByte
 }
 
-func GetFirstTSFile(m3u8Content string) string {
-	lines := strings.Split(m3u8Content, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		if strings.Contains(line, ".ts") {
-			return line
-		}
+// 判断数据是否以 fMP4 的 box 开头
+func isMP4Box(prefix []byte) bool {
+	if len(prefix) < 8 {
+		return false
+	}
+	switch string(prefix[4:8]) {
+	case "ftyp", "styp", "moov", "moof", "sidx":
+		return true
 	}
-	return ""
+	return false
 }
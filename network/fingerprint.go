@@ -0,0 +1,108 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/fingerprint"
+	"github.com/qist/iptv-static-scan/output"
+)
+
+var (
+	fingerprintDB  *fingerprint.DB
+	faviconEnabled bool
+	faviconCache   sync.Map // 服务器 -> *faviconEntry，同一服务器只请求一次 favicon
+	identifyCache  sync.Map // 服务器 -> *output.FingerprintInfo，只缓存识别成功的结果
+)
+
+type faviconEntry struct {
+	once sync.Once
+	hash int32
+	ok   bool
+}
+
+// 加载配置文件中的指纹和内置指纹库
+func InitFingerprints(cfg *config.Config) error {
+	var custom []fingerprint.Fingerprint
+	if !cfg.Fingerprints.IsZero() {
+		if err := cfg.Fingerprints.Decode(&custom); err != nil {
+			return fmt.Errorf("解析指纹配置失败: %v", err)
+		}
+	}
+	db, err := fingerprint.New(custom)
+	if err != nil {
+		return err
+	}
+	fingerprintDB = db
+	faviconEnabled = cfg.FingerprintFavicon
+	return nil
+}
+
+// 识别响应对应的服务软件，识别成功的结果按服务器缓存
+// 同一端口上的流媒体和接口可能只有部分响应能匹配，未识别的不缓存，favicon 仍只请求一次
+// 先关闭探测连接再识别，请求 favicon 时不和流媒体连接同时占用服务器的连接数
+func identify(p *Probe, cfg *config.Config) *output.FingerprintInfo {
+	p.Close()
+	t := p.Target
+	key := fmt.Sprintf("%s://%s:%d|%s", t.scheme(), t.IP, t.Port, t.Host)
+	if v, ok := identifyCache.Load(key); ok {
+		return v.(*output.FingerprintInfo)
+	}
+	info := match(p, cfg)
+	if info == nil {
+		return nil
+	}
+	v, _ := identifyCache.LoadOrStore(key, info)
+	return v.(*output.FingerprintInfo)
+}
+
+// 按指纹库匹配响应，使用已读取的响应体，不额外读取
+func match(p *Probe, cfg *config.Config) *output.FingerprintInfo {
+	resp := &fingerprint.Response{
+		Path:   "/" + strings.SplitN(p.Target.Path, "?", 2)[0],
+		Header: p.Resp.Header,
+		Body:   p.prefix,
+	}
+	if faviconEnabled {
+		resp.Favicon = func() (int32, bool) { return faviconHash(p.Target, cfg) }
+	}
+	m := fingerprintDB.Identify(resp)
+	if m == nil {
+		return nil
+	}
+	return &output.FingerprintInfo{
+		Product:     m.Product,
+		Version:     m.Version,
+		Title:       m.Title,
+		FaviconHash: m.FaviconHash,
+	}
+}
+
+// 请求 /favicon.ico 并计算哈希，结果按服务器缓存
+func faviconHash(t *Target, cfg *config.Config) (int32, bool) {
+	key := fmt.Sprintf("%s://%s:%d|%s", t.scheme(), t.IP, t.Port, t.Host)
+	v, _ := faviconCache.LoadOrStore(key, &faviconEntry{})
+	e := v.(*faviconEntry)
+	e.once.Do(func() {
+		ft := t.WithPath("favicon.ico")
+		p, err := Fetch(ft, cfg)
+		if err != nil {
+			log.Printf("请求 %s 失败: %v\n", ft.URL(), err)
+			return
+		}
+		defer p.Close()
+		if p.Resp.StatusCode != http.StatusOK {
+			return
+		}
+		body, err := p.ReadText()
+		if err != nil || len(body) == 0 {
+			return
+		}
+		e.hash, e.ok = fingerprint.FaviconHash(body), true
+	})
+	return e.hash, e.ok
+}
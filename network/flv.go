@@ -0,0 +1,359 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/qist/iptv-static-scan/output"
+)
+
+const (
+	flvHeaderSize    = 9
+	flvTagHeaderSize = 11
+	flvMaxScriptSize = 1 << 16 // 脚本标签最多缓存的字节数
+
+	flvTagAudio  = 8
+	flvTagVideo  = 9
+	flvTagScript = 18
+)
+
+// FLV 视频编码 ID
+var flvVideoCodecs = map[byte]string{
+	2:  "H.263",
+	3:  "Screen",
+	4:  "VP6",
+	5:  "VP6A",
+	6:  "Screen2",
+	7:  "H.264",
+	12: "H.265", // 国内常用的 HEVC 扩展
+}
+
+// FLV 音频编码 ID
+var flvAudioCodecs = map[byte]string{
+	0:  "PCM",
+	1:  "ADPCM",
+	2:  "MP3",
+	3:  "PCM",
+	7:  "G.711A",
+	8:  "G.711U",
+	10: "AAC",
+	11: "Speex",
+	14: "MP3",
+}
+
+// FLVParser 增量解析 FLV 数据，检查文件头和标签结构，读取 onMetaData
+type FLVParser struct {
+	buf       []byte
+	headerOK  bool
+	skip      int // 当前标签剩余需要跳过的数据
+	prevSize  int // 当前标签的总长度，用于校验之后的 PreviousTagSize
+	needPrev  bool
+	err       error
+	videoTags int
+	audioTags int
+	video     string
+	audio     string
+	meta      map[string]any
+}
+
+// 创建 FLV 解析器
+func NewFLVParser() *FLVParser {
+	return &FLVParser{}
+}
+
+// 写入下载到的数据，结构错误会记录下来，由 Check 返回
+func (f *FLVParser) Write(p []byte) (int, error) {
+	if f.err != nil {
+		return len(p), nil
+	}
+	f.buf = append(f.buf, p...)
+	off := 0
+	for f.err == nil {
+		if f.skip > 0 {
+			n := min(f.skip, len(f.buf)-off)
+			f.skip -= n
+			off += n
+			if f.skip > 0 {
+				break
+			}
+		}
+		if !f.headerOK {
+			if len(f.buf)-off < flvHeaderSize+4 {
+				break
+			}
+			f.err = f.parseHeader(f.buf[off:])
+			// 跳过文件头和之后的 PreviousTagSize0
+			f.skip = int(binary.BigEndian.Uint32(f.buf[off+5:])) + 4
+			continue
+		}
+		if f.needPrev {
+			if len(f.buf)-off < 4 {
+				break
+			}
+			if size := int(binary.BigEndian.Uint32(f.buf[off:])); size != f.prevSize {
+				f.err = fmt.Errorf("PreviousTagSize 为 %d，应为 %d", size, f.prevSize)
+				break
+			}
+			off += 4
+			f.needPrev = false
+			continue
+		}
+		if len(f.buf)-off < flvTagHeaderSize {
+			break
+		}
+		n, ok := f.parseTag(f.buf[off:])
+		if !ok {
+			break
+		}
+		off += n
+	}
+	f.buf = append(f.buf[:0], f.buf[off:]...)
+	return len(p), nil
+}
+
+func (f *FLVParser) parseHeader(b []byte) error {
+	if string(b[:3]) != "FLV" {
+		return errors.New("不是 FLV 数据，缺少 FLV 签名")
+	}
+	if b[3] != 1 {
+		return fmt.Errorf("不支持的 FLV 版本 %d", b[3])
+	}
+	size := binary.BigEndian.Uint32(b[5:])
+	if size < flvHeaderSize || size > 1024 {
+		return fmt.Errorf("FLV 文件头长度 %d 无效", size)
+	}
+	f.headerOK = true
+	return nil
+}
+
+// 解析一个标签，返回已消费的字节数；数据不够时返回 false
+func (f *FLVParser) parseTag(b []byte) (int, bool) {
+	tagType := b[0] & 0x1f
+	dataSize := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+	if b[0]&0x20 != 0 {
+		f.err = errors.New("FLV 标签被加密")
+		return 0, false
+	}
+	f.prevSize = flvTagHeaderSize + dataSize
+	f.needPrev = true
+
+	switch tagType {
+	case flvTagScript:
+		if dataSize > flvMaxScriptSize {
+			f.skip = dataSize
+			return flvTagHeaderSize, true
+		}
+		if len(b) < flvTagHeaderSize+dataSize {
+			f.needPrev = false
+			return 0, false // 等待完整的脚本标签
+		}
+		if f.meta == nil {
+			f.meta = parseOnMetaData(b[flvTagHeaderSize : flvTagHeaderSize+dataSize])
+		}
+		return flvTagHeaderSize + dataSize, true
+	case flvTagVideo, flvTagAudio:
+		if dataSize == 0 {
+			return flvTagHeaderSize, true
+		}
+		if len(b) < flvTagHeaderSize+1 {
+			f.needPrev = false
+			return 0, false
+		}
+		first := b[flvTagHeaderSize]
+		if tagType == flvTagVideo {
+			f.videoTags++
+			if f.video == "" {
+				f.video = flvVideoCodecs[first&0x0f]
+			}
+		} else {
+			f.audioTags++
+			if f.audio == "" {
+				f.audio = flvAudioCodecs[first>>4]
+			}
+		}
+		f.skip = dataSize
+		return flvTagHeaderSize, true
+	default:
+		f.err = fmt.Errorf("未知的 FLV 标签类型 %d", tagType)
+		return 0, false
+	}
+}
+
+// 解析 onMetaData 脚本标签，失败时返回 nil
+func parseOnMetaData(b []byte) map[string]any {
+	d := &amfDecoder{b: b}
+	name, ok := d.value().(string)
+	if !ok || name != "onMetaData" {
+		return nil
+	}
+	// 数据不完整时保留已解析出的字段
+	meta, _ := d.value().(map[string]any)
+	return meta
+}
+
+// amfDecoder 最小的 AMF0 解码器，只用于读取 onMetaData
+type amfDecoder struct {
+	b     []byte
+	off   int
+	err   error
+	depth int
+}
+
+func (d *amfDecoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if d.off+n > len(d.b) {
+		d.err = errors.New("AMF0 数据不完整")
+		return nil
+	}
+	v := d.b[d.off : d.off+n]
+	d.off += n
+	return v
+}
+
+func (d *amfDecoder) str(long bool) string {
+	var n int
+	if long {
+		b := d.read(4)
+		if b == nil {
+			return ""
+		}
+		n = int(binary.BigEndian.Uint32(b))
+	} else {
+		b := d.read(2)
+		if b == nil {
+			return ""
+		}
+		n = int(binary.BigEndian.Uint16(b))
+	}
+	return string(d.read(n))
+}
+
+// 读取对象或 ECMA 数组的属性，直到结束标记 00 00 09
+func (d *amfDecoder) props() map[string]any {
+	m := make(map[string]any)
+	for d.err == nil {
+		key := d.str(false)
+		if key == "" {
+			if end := d.read(1); end != nil && end[0] != 0x09 {
+				d.err = errors.New("AMF0 对象结束标记错误")
+			}
+			return m
+		}
+		m[key] = d.value()
+	}
+	return m
+}
+
+func (d *amfDecoder) value() any {
+	marker := d.read(1)
+	if marker == nil {
+		return nil
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > 16 {
+		d.err = errors.New("AMF0 嵌套过深")
+		return nil
+	}
+	switch marker[0] {
+	case 0x00: // number
+		b := d.read(8)
+		if b == nil {
+			return nil
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	case 0x01: // boolean
+		b := d.read(1)
+		return b != nil && b[0] != 0
+	case 0x02: // string
+		return d.str(false)
+	case 0x03: // object
+		return d.props()
+	case 0x05, 0x06: // null、undefined
+		return nil
+	case 0x07: // reference
+		d.read(2)
+		return nil
+	case 0x08: // ECMA array，数量不可靠，按结束标记读取
+		d.read(4)
+		return d.props()
+	case 0x0a: // strict array
+		b := d.read(4)
+		if b == nil {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint32(b))
+		var list []any
+		for i := 0; i < n && d.err == nil; i++ {
+			list = append(list, d.value())
+		}
+		return list
+	case 0x0b: // date
+		d.read(10)
+		return nil
+	case 0x0c: // long string
+		return d.str(true)
+	default:
+		d.err = fmt.Errorf("不支持的 AMF0 类型 %d", marker[0])
+		return nil
+	}
+}
+
+// 返回解析结果，未找到 FLV 文件头时返回 nil
+func (f *FLVParser) Info() *output.StreamInfo {
+	if !f.headerOK {
+		return nil
+	}
+	info := &output.StreamInfo{
+		Format:     "flv",
+		VideoCodec: f.video,
+		VideoTags:  f.videoTags,
+		AudioTags:  f.audioTags,
+	}
+	if f.audio != "" {
+		info.AudioCodecs = []string{f.audio}
+	}
+	if f.meta != nil {
+		info.Width = int(metaNumber(f.meta, "width"))
+		info.Height = int(metaNumber(f.meta, "height"))
+		info.FrameRate = math.Round(metaNumber(f.meta, "framerate")*100) / 100
+		info.VideoBitrate = math.Round(metaNumber(f.meta, "videodatarate"))
+		info.AudioBitrate = math.Round(metaNumber(f.meta, "audiodatarate"))
+	}
+	// 采样数据中没有音视频标签时使用元数据中的编码 ID
+	if f.meta != nil && f.videoTags == 0 && f.audioTags == 0 {
+		if id, ok := f.meta["videocodecid"].(float64); ok {
+			info.VideoCodec = flvVideoCodecs[byte(id)]
+		}
+		if id, ok := f.meta["audiocodecid"].(float64); ok && flvAudioCodecs[byte(id)] != "" {
+			info.AudioCodecs = []string{flvAudioCodecs[byte(id)]}
+		}
+	}
+	return info
+}
+
+func metaNumber(meta map[string]any, key string) float64 {
+	v, _ := meta[key].(float64)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+// 判断流是否合格，不合格时返回原因
+func (f *FLVParser) Check() error {
+	if f.err != nil {
+		return f.err
+	}
+	if !f.headerOK {
+		return errors.New("数据不足，未读取到 FLV 文件头")
+	}
+	if f.videoTags == 0 {
+		return errors.New("采样数据中没有视频标签")
+	}
+	return nil
+}
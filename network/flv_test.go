@@ -0,0 +1,248 @@
+package network
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// AMF0 编码，用于构造测试数据
+func amfNumber(v float64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{0x00}, math.Float64bits(v))
+}
+
+func amfBool(v bool) []byte {
+	if v {
+		return []byte{0x01, 1}
+	}
+	return []byte{0x01, 0}
+}
+
+func amfKey(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+func amfString(s string) []byte {
+	return append([]byte{0x02}, amfKey(s)...)
+}
+
+// kv 依次为属性名和已编码的值
+func amfProps(kv ...any) []byte {
+	var b []byte
+	for i := 0; i+1 < len(kv); i += 2 {
+		b = append(b, amfKey(kv[i].(string))...)
+		b = append(b, kv[i+1].([]byte)...)
+	}
+	return append(b, 0, 0, 0x09)
+}
+
+func amfObject(kv ...any) []byte {
+	return append([]byte{0x03}, amfProps(kv...)...)
+}
+
+func amfECMA(kv ...any) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{0x08}, uint32(len(kv)/2))
+	return append(b, amfProps(kv...)...)
+}
+
+func amfStrict(values ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{0x0a}, uint32(len(values)))
+	return slices.Concat(append([][]byte{b}, values...)...)
+}
+
+func flvHeader() []byte {
+	return []byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0}
+}
+
+// 一个完整的标签，包括之后的 PreviousTagSize
+func flvTag(tagType byte, data []byte) []byte {
+	n := len(data)
+	b := []byte{tagType, byte(n >> 16), byte(n >> 8), byte(n), 0, 0, 0, 0, 0, 0, 0}
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, uint32(flvTagHeaderSize+n))
+}
+
+var flvMetaData = slices.Concat(amfString("onMetaData"), amfECMA(
+	"width", amfNumber(1920),
+	"height", amfNumber(1080),
+	"framerate", amfNumber(25),
+	"videocodecid", amfNumber(7),
+	"videodatarate", amfNumber(4000),
+	"audiodatarate", amfNumber(128),
+))
+
+// 文件头、onMetaData、H.264 视频和 AAC 音频标签
+func flvStream(videoTags, audioTags int) []byte {
+	b := slices.Concat(flvHeader(), flvTag(flvTagScript, flvMetaData))
+	for i := range max(videoTags, audioTags) {
+		if i < videoTags {
+			b = append(b, flvTag(flvTagVideo, append([]byte{0x17, 1}, make([]byte, 300)...))...)
+		}
+		if i < audioTags {
+			b = append(b, flvTag(flvTagAudio, append([]byte{0xaf, 1}, make([]byte, 40)...))...)
+		}
+	}
+	return b
+}
+
+func TestFLVParser(t *testing.T) {
+	stream := flvStream(5, 8)
+
+	mismatch := flvStream(2, 0)
+	binary.BigEndian.PutUint32(mismatch[len(mismatch)-4:], 99)
+	mismatch = append(mismatch, flvTag(flvTagVideo, []byte{0x17, 1, 0})...)
+
+	encrypted := slices.Concat(flvStream(1, 0), flvTag(flvTagVideo|0x20, []byte{0x17, 1, 0}))
+
+	tests := []struct {
+		name      string
+		data      []byte
+		videoTags int
+		audioTags int
+		wantErr   string // Check 返回的错误中包含的内容，为空时应通过
+	}{
+		{name: "valid", data: stream, videoTags: 5, audioTags: 8},
+		{name: "truncated in tag", data: stream[:len(stream)-100], videoTags: 5, audioTags: 7},
+		{name: "audio only", data: flvStream(0, 4), audioTags: 4, wantErr: "没有视频标签"},
+		{name: "previous tag size mismatch", data: mismatch, videoTags: 2, wantErr: "PreviousTagSize"},
+		{name: "encrypted", data: encrypted, videoTags: 1, wantErr: "加密"},
+		{name: "html", data: []byte("<html><body>404 Not Found</body></html>"), wantErr: "FLV 签名"},
+		{name: "header only", data: flvHeader()[:5], wantErr: "文件头"},
+		{name: "unknown tag", data: slices.Concat(flvHeader(), flvTag(3, []byte{1, 2, 3})), wantErr: "标签类型"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 整块写入和分多次写入的结果应相同
+			for _, step := range []int{len(tt.data), 1, 7, 64} {
+				f := NewFLVParser()
+				for i := 0; i < len(tt.data); i += step {
+					f.Write(tt.data[i:min(i+step, len(tt.data))])
+				}
+				err := f.Check()
+				switch {
+				case tt.wantErr == "" && err != nil:
+					t.Errorf("step %d: Check() = %v", step, err)
+				case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+					t.Errorf("step %d: Check() = %v, want error containing %q", step, err, tt.wantErr)
+				}
+				if f.videoTags != tt.videoTags || f.audioTags != tt.audioTags {
+					t.Errorf("step %d: video=%d audio=%d, want %d %d", step, f.videoTags, f.audioTags, tt.videoTags, tt.audioTags)
+				}
+			}
+		})
+	}
+}
+
+func TestFLVParserInfo(t *testing.T) {
+	f := NewFLVParser()
+	f.Write(flvStream(3, 3))
+	info := f.Info()
+	if info == nil {
+		t.Fatal("Info() = nil")
+	}
+	if info.Format != "flv" || info.VideoCodec != "H.264" || !slices.Equal(info.AudioCodecs, []string{"AAC"}) {
+		t.Errorf("format=%q video=%q audio=%v", info.Format, info.VideoCodec, info.AudioCodecs)
+	}
+	if info.Width != 1920 || info.Height != 1080 || info.FrameRate != 25 || info.VideoBitrate != 4000 || info.AudioBitrate != 128 {
+		t.Errorf("metadata = %dx%d %vfps %v/%vkbps", info.Width, info.Height, info.FrameRate, info.VideoBitrate, info.AudioBitrate)
+	}
+
+	// 没有音视频标签时使用元数据中的编码 ID
+	f = NewFLVParser()
+	f.Write(flvStream(0, 0))
+	if info := f.Info(); info.VideoCodec != "H.264" {
+		t.Errorf("VideoCodec from metadata = %q, want H.264", info.VideoCodec)
+	}
+}
+
+func TestParseOnMetaData(t *testing.T) {
+	full := slices.Concat(amfString("onMetaData"), amfECMA(
+		"duration", amfNumber(0),
+		"width", amfNumber(1280),
+		"stereo", amfBool(true),
+		"encoder", amfString("Lavf58.29.100"),
+		"keyframes", amfObject(
+			"times", amfStrict(amfNumber(0), amfNumber(2), amfNumber(4)),
+			"filepositions", amfStrict(),
+		),
+		"nothing", []byte{0x05},
+		"height", amfNumber(720),
+	))
+
+	t.Run("ecma array", func(t *testing.T) {
+		meta := parseOnMetaData(full)
+		if meta == nil {
+			t.Fatal("parseOnMetaData() = nil")
+		}
+		if meta["width"] != 1280.0 || meta["height"] != 720.0 || meta["stereo"] != true || meta["encoder"] != "Lavf58.29.100" {
+			t.Errorf("meta = %v", meta)
+		}
+		if v, ok := meta["nothing"]; !ok || v != nil {
+			t.Errorf("null value = %v, %v", v, ok)
+		}
+		kf, ok := meta["keyframes"].(map[string]any)
+		if !ok {
+			t.Fatalf("keyframes = %T", meta["keyframes"])
+		}
+		if times, _ := kf["times"].([]any); !slices.Equal(times, []any{0.0, 2.0, 4.0}) {
+			t.Errorf("times = %v", kf["times"])
+		}
+		if positions, _ := kf["filepositions"].([]any); len(positions) != 0 {
+			t.Errorf("filepositions = %v", kf["filepositions"])
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		// 截断在 keyframes 中，之前的字段保留
+		cut := slices.Index(full, 'k') + 20
+		meta := parseOnMetaData(full[:cut])
+		if meta == nil {
+			t.Fatal("parseOnMetaData() = nil")
+		}
+		if meta["width"] != 1280.0 {
+			t.Errorf("width = %v", meta["width"])
+		}
+		if _, ok := meta["height"]; ok {
+			t.Errorf("height should be missing: %v", meta)
+		}
+		for n := range len(full) {
+			parseOnMetaData(full[:n]) // 任意位置截断都不应 panic
+		}
+	})
+
+	t.Run("not metadata", func(t *testing.T) {
+		data := slices.Concat(amfString("onTextData"), amfECMA("text", amfString("x")))
+		if meta := parseOnMetaData(data); meta != nil {
+			t.Errorf("parseOnMetaData() = %v, want nil", meta)
+		}
+	})
+
+	t.Run("huge strict array count", func(t *testing.T) {
+		data := slices.Concat(amfString("onMetaData"), amfECMA("list", []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0x00}))
+		parseOnMetaData(data) // 数量不可信，数据用完即停止
+	})
+
+	t.Run("depth limit", func(t *testing.T) {
+		nested := amfNumber(1)
+		for range 32 {
+			nested = amfObject("a", nested)
+		}
+		d := &amfDecoder{b: nested}
+		d.value()
+		if d.err == nil || !strings.Contains(d.err.Error(), "嵌套过深") {
+			t.Errorf("err = %v, want depth error", d.err)
+		}
+
+		shallow := amfNumber(1)
+		for range 8 {
+			shallow = amfObject("a", shallow)
+		}
+		d = &amfDecoder{b: shallow}
+		d.value()
+		if d.err != nil {
+			t.Errorf("err = %v for 8 levels", d.err)
+		}
+	})
+}
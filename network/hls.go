@@ -0,0 +1,419 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/hls"
+	"github.com/qist/iptv-static-scan/output"
+)
+
+// 主播放列表最多嵌套的层数，防止播放列表互相引用
+const hlsMaxDepth = 3
+
+// 直播检查时播放列表没有 EXT-X-TARGETDURATION 使用的等待时间
+const hlsDefaultTargetDuration = 10 * time.Second
+
+// 直播检查默认最多等待的时间
+const hlsDefaultLivenessMaxWait = 12 * time.Second
+
+// 不下载分片时读取的 TS 包数，用于确认分片是 TS 数据
+const segmentCheckPackets = 8
+
+// hlsWalker 逐级检查一个 m3u8 播放列表
+type hlsWalker struct {
+	cfg      *config.Config
+	first    *output.Result  // 第一个下载成功的分片，耗时、速度和流信息写入命中结果
+	liveness string          // 第一个检查通过的媒体播放列表的直播状态
+	keys     map[string]bool // 已请求过的密钥地址是否可以获取
+}
+
+// hlsMedia 一个媒体播放列表的检查结果
+type hlsMedia struct {
+	downloaded int    // 下载成功的分片数
+	encryption string // 加密状态
+	keyMethod  string
+}
+
+// 检查播放列表：主播放列表逐个检查各码率的子播放列表，媒体播放列表检查分片
+// download_ts 为 true 时下载分片，否则只请求第一个分片的开头
+// fetched 为请求该播放列表的时间，用于直播检查
+func (w *hlsWalker) walk(t *Target, pl *hls.Playlist, fetched time.Time, depth int) (*output.HLSInfo, error) {
+	info := &output.HLSInfo{Master: pl.Master, Version: pl.Version}
+	if !pl.Master {
+		m, err := w.checkMedia(t, pl, fetched)
+		info.TargetDuration = pl.TargetDuration
+		info.Segments = len(pl.Segments)
+		info.Container = pl.Container()
+		info.Downloaded = m.downloaded
+		info.Encryption, info.KeyMethod = m.encryption, m.keyMethod
+		return info, err
+	}
+
+	variants := pl.Variants
+	if limit := w.cfg.HLSMaxVariants; limit > 0 && len(variants) > limit {
+		variants = variants[:limit]
+	}
+	ok := 0
+	for _, v := range variants {
+		rv := output.HLSVariant{
+			URL:              v.URI,
+			Bandwidth:        v.Bandwidth,
+			AverageBandwidth: v.AverageBandwidth,
+			Resolution:       v.Resolution,
+			FrameRate:        v.FrameRate,
+			Codecs:           v.Codecs,
+		}
+		vt, err := resolveTarget(t, v.URI)
+		if err == nil {
+			rv.URL = vt.URL()
+			err = w.checkVariant(vt, &rv, depth)
+		}
+		if err != nil {
+			log.Printf("检查子播放列表 %s 失败: %v\n", rv.URL, err)
+			rv.Error = err.Error()
+		} else {
+			rv.OK = true
+			ok++
+			if encryptionRank(rv.Encryption) > encryptionRank(info.Encryption) {
+				info.Encryption, info.KeyMethod = rv.Encryption, rv.KeyMethod
+			}
+		}
+		info.Variants = append(info.Variants, rv)
+	}
+	if ok == 0 {
+		return info, errors.New("没有可用的子播放列表")
+	}
+	return info, nil
+}
+
+// 加密状态的优先级，主播放列表取可用码率中优先级最高的状态
+func encryptionRank(encryption string) int {
+	switch encryption {
+	case output.HLSClear:
+		return 3
+	case output.HLSKeyReachable:
+		return 2
+	case output.HLSKeyUnreachable:
+		return 1
+	}
+	return 0
+}
+
+// 请求并检查一个码率的子播放列表
+func (w *hlsWalker) checkVariant(t *Target, rv *output.HLSVariant, depth int) error {
+	fetched := time.Now()
+	pl, err := fetchPlaylist(t, w.cfg)
+	if err != nil {
+		return err
+	}
+	if pl.Master {
+		// 子播放列表仍是主播放列表时继续跟随，使用其中第一个可用的码率
+		if depth+1 >= hlsMaxDepth {
+			return errors.New("主播放列表嵌套过深")
+		}
+		sub, err := w.walk(t, pl, fetched, depth+1)
+		if err != nil {
+			return err
+		}
+		for _, sv := range sub.Variants {
+			if sv.OK {
+				rv.Segments, rv.Container, rv.Downloaded = sv.Segments, sv.Container, sv.Downloaded
+				rv.Encryption, rv.KeyMethod = sv.Encryption, sv.KeyMethod
+				break
+			}
+		}
+		return nil
+	}
+	m, err := w.checkMedia(t, pl, fetched)
+	rv.Segments, rv.Container, rv.Downloaded = len(pl.Segments), pl.Container(), m.downloaded
+	rv.Encryption, rv.KeyMethod = m.encryption, m.keyMethod
+	return err
+}
+
+// 检查媒体播放列表：请求 EXT-X-KEY 的密钥，download_ts 为 true 时下载前 hlsSegments 个分片，否则只请求第一个分片的开头
+func (w *hlsWalker) checkMedia(t *Target, pl *hls.Playlist, fetched time.Time) (hlsMedia, error) {
+	var m hlsMedia
+	if len(pl.Segments) == 0 {
+		return m, errors.New("播放列表中没有分片")
+	}
+	m.encryption = output.HLSClear
+	if key := pl.Key(); key != nil {
+		m.keyMethod = key.Method
+		m.encryption = w.checkKey(t, key)
+	}
+	n, err := w.downloadSegments(t, pl)
+	m.downloaded = n
+	// 只对第一个检查通过的媒体播放列表做直播检查，避免每个码率都等待
+	if err == nil && w.cfg.HLSLiveness && w.liveness == "" {
+		w.liveness = checkLiveness(t, pl, fetched, w.cfg)
+	}
+	return m, err
+}
+
+// 请求 EXT-X-KEY 中的密钥，返回加密状态，同一密钥地址只请求一次
+func (w *hlsWalker) checkKey(t *Target, key *hls.Key) string {
+	kt, err := resolveTarget(t, key.URI)
+	if err == nil && key.URI == "" {
+		err = errors.New("EXT-X-KEY 缺少 URI")
+	}
+	if err != nil {
+		// skd://、data: 等 DRM 密钥地址同样无法获取
+		log.Printf("%s 的密钥无法获取: %v\n", t.URL(), err)
+		return output.HLSKeyUnreachable
+	}
+	keyURL := kt.URL()
+	reachable, ok := w.keys[keyURL]
+	if !ok {
+		err := fetchKey(kt, key, w.cfg)
+		if err != nil {
+			log.Printf("请求密钥 %s 失败: %v\n", keyURL, err)
+		}
+		reachable = err == nil
+		if w.keys == nil {
+			w.keys = make(map[string]bool)
+		}
+		w.keys[keyURL] = reachable
+	}
+	if reachable {
+		return output.HLSKeyReachable
+	}
+	return output.HLSKeyUnreachable
+}
+
+// 请求密钥，identity 格式的密钥应为 16 字节
+func fetchKey(t *Target, key *hls.Key, cfg *config.Config) error {
+	p, err := Fetch(t, cfg)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if p.Resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("状态码 %d", p.Resp.StatusCode)
+	}
+	if key.KeyFormat != "" && key.KeyFormat != "identity" {
+		return nil
+	}
+	body, err := p.Peek(17)
+	if err != nil {
+		return err
+	}
+	if len(body) != 16 {
+		return errors.New("密钥长度不是 16 字节")
+	}
+	return nil
+}
+
+// 按 hlsExcludeEncrypted 判断是否丢弃加密的播放列表：all 丢弃所有加密的，unreachable 只丢弃密钥无法获取的
+func excludeEncrypted(encryption string, cfg *config.Config) bool {
+	switch strings.ToLower(cfg.HLSExcludeEncrypted) {
+	case "all":
+		return encryption == output.HLSKeyReachable || encryption == output.HLSKeyUnreachable
+	case "unreachable":
+		return encryption == output.HLSKeyUnreachable
+	}
+	return false
+}
+
+// download_ts 为 true 时下载前 hlsSegments 个分片，返回下载成功的分片数
+// 否则只请求第一个分片并检查开头的数据，确认分片可以获取
+func (w *hlsWalker) downloadSegments(t *Target, pl *hls.Playlist) (int, error) {
+	count := min(max(w.cfg.HLSSegments, 1), len(pl.Segments))
+	if !w.cfg.DownloadTS {
+		count = 1
+	}
+	downloaded := 0
+	initMap := ""
+	for _, seg := range pl.Segments[:count] {
+		// fMP4 分片先检查 EXT-X-MAP 指定的初始化分片
+		if seg.Map != "" && seg.Map != initMap {
+			mt, err := resolveTarget(t, seg.Map)
+			if err != nil {
+				return downloaded, err
+			}
+			if err := checkInitSegment(mt, w.cfg); err != nil {
+				return downloaded, fmt.Errorf("初始化分片 %s: %v", mt.URL(), err)
+			}
+			initMap = seg.Map
+		}
+		st, err := resolveTarget(t, seg.URI)
+		if err != nil {
+			return downloaded, err
+		}
+		st.encrypted = seg.Key != nil && seg.Key.Method == "AES-128"
+		if !w.cfg.DownloadTS {
+			if err := checkSegment(st, w.cfg); err != nil {
+				return downloaded, fmt.Errorf("分片 %s: %v", st.URL(), err)
+			}
+			continue
+		}
+		result := downloadTarget(st, output.KindM3U8, w.cfg)
+		if result == nil {
+			return downloaded, fmt.Errorf("分片 %s 下载失败", st.URL())
+		}
+		if w.first == nil {
+			w.first = result
+		}
+		downloaded++
+	}
+	return downloaded, nil
+}
+
+// 请求分片并检查开头的数据，读取前缀后即断开连接，不下载整个分片
+// 应为 TS 的分片在 tsValidate 开启时需要找到同步字节
+func checkSegment(t *Target, cfg *config.Config) error {
+	p, err := Fetch(t, cfg)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if p.Resp.StatusCode != http.StatusOK && p.Resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("状态码 %d", p.Resp.StatusCode)
+	}
+	prefix, err := p.Peek(tsPacketSize * segmentCheckPackets)
+	if err != nil {
+		return err
+	}
+	if len(prefix) == 0 {
+		return errors.New("分片没有数据")
+	}
+	if cfg.TSValidate && expectTS(p, output.KindM3U8) {
+		ts := NewTSValidator()
+		ts.Write(prefix)
+		if ts.Info() == nil {
+			return errors.New("不是 MPEG-TS 数据")
+		}
+	}
+	return nil
+}
+
+// 判断媒体播放列表是否为直播：带 EXT-X-ENDLIST 的为点播，
+// 否则在首次请求约一个 EXT-X-TARGETDURATION 后再次请求，EXT-X-MEDIA-SEQUENCE 或分片列表有变化为直播，没有变化为停滞
+// 再次请求失败时返回空字符串
+func checkLiveness(t *Target, pl *hls.Playlist, fetched time.Time, cfg *config.Config) string {
+	if pl.EndList {
+		return output.HLSVOD
+	}
+	wait := hlsDefaultTargetDuration
+	if pl.TargetDuration > 0 {
+		wait = time.Duration(pl.TargetDuration * float64(time.Second))
+	}
+	maxWait := hlsDefaultLivenessMaxWait
+	if cfg.HLSLivenessMaxWait > 0 {
+		maxWait = time.Duration(cfg.HLSLivenessMaxWait) * time.Second
+	}
+	wait = min(wait, maxWait)
+	// 下载分片已经花费的时间计入等待时间
+	time.Sleep(wait - time.Since(fetched))
+
+	next, err := fetchPlaylist(t, cfg)
+	if err != nil {
+		log.Printf("直播检查再次请求 %s 失败: %v\n", t.URL(), err)
+		return ""
+	}
+	if next.EndList {
+		return output.HLSVOD
+	}
+	if next.MediaSequence != pl.MediaSequence || len(next.Segments) != len(pl.Segments) ||
+		(len(next.Segments) > 0 && next.Segments[len(next.Segments)-1].URI != pl.Segments[len(pl.Segments)-1].URI) {
+		return output.HLSLive
+	}
+	log.Printf("%s 的分片列表在 %v 后没有变化\n", t.URL(), wait)
+	return output.HLSFrozen
+}
+
+// 请求并解析播放列表
+func fetchPlaylist(t *Target, cfg *config.Config) (*hls.Playlist, error) {
+	p, err := Fetch(t, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	if p.Resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("状态码 %d", p.Resp.StatusCode)
+	}
+	body, err := p.ReadText()
+	if err != nil {
+		return nil, err
+	}
+	return hls.Parse(string(body))
+}
+
+// 请求 fMP4 初始化分片，检查开头是否为 MP4 box
+func checkInitSegment(t *Target, cfg *config.Config) error {
+	p, err := Fetch(t, cfg)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if p.Resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("状态码 %d", p.Resp.StatusCode)
+	}
+	prefix, err := p.Peek(8)
+	if err != nil {
+		return err
+	}
+	if !isMP4Box(prefix) {
+		return errors.New("不是 MP4 数据")
+	}
+	return nil
+}
+
+// 将播放列表中的地址解析为目标
+// 相对地址基于播放列表的地址解析，指向同一服务器的地址沿用原目标的 Host、SNI 和出口地址，
+// 指向其他服务器的绝对地址生成新的目标
+func resolveTarget(t *Target, uri string) (*Target, error) {
+	base, err := url.Parse(t.URL())
+	if err != nil {
+		return nil, err
+	}
+	ref, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("无效的地址 %s: %v", uri, err)
+	}
+	u := base.ResolveReference(ref)
+	urlPath := strings.TrimPrefix(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		urlPath += "?" + u.RawQuery
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == SchemeHTTPS {
+			port = "443"
+		}
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("无效的端口 %s", uri)
+	}
+	if u.Scheme != SchemeHTTP && u.Scheme != SchemeHTTPS {
+		return nil, fmt.Errorf("不支持的协议 %s", uri)
+	}
+
+	host := u.Hostname()
+	if u.Scheme == t.scheme() && portNum == t.Port &&
+		(host == strings.Trim(t.IP, "[]") || (t.Host != "" && host == hostWithoutPort(t.Host))) {
+		return t.WithPath(urlPath), nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	return &Target{
+		IP:         host,
+		Port:       portNum,
+		Path:       urlPath,
+		Scheme:     u.Scheme,
+		SourceAddr: t.SourceAddr,
+		Source:     t.Source,
+	}, nil
+}
@@ -1,40 +1,118 @@
 package network
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/qist/iptv-static-scan/config"
 )
 
+// 连接池默认值，未配置时使用
+const (
+	defaultMaxIdleConns        = 2000
+	defaultMaxIdleConnsPerHost = 4
+	defaultIdleConnTimeout     = 30
+)
+
+// clientKey 区分需要独立连接池的客户端
+// 连接池按 协议+地址 复用连接，SNI 或出口地址不同的连接不能混用，因此各自使用单独的 Transport
+type clientKey struct {
+	serverName string
+	sourceAddr string // 绑定的本地出口地址
+}
+
+var (
+	clientsMu  sync.Mutex
+	clients    = make(map[clientKey]*http.Client)
+	transports []*http.Transport
+)
+
+// 返回扫描器共用的 HTTP 客户端，首次调用时按配置创建
+// 所有请求共用同一个 Transport，同一主机的探测、内容检测和下载可以复用连接
 func CreateHTTPClient(cfg *config.Config) *http.Client {
-	tr := &http.Transport{
-		DialContext: (&net.Dialer{
+	return clientFor(cfg, clientKey{})
+}
+
+// 返回目标使用的客户端，https 目标指定了 SNI 时使用对应的连接池
+func clientForTarget(t *Target, cfg *config.Config) *http.Client {
+	key := clientKey{sourceAddr: t.SourceAddr}
+	if t.scheme() == SchemeHTTPS {
+		key.serverName = hostWithoutPort(t.serverName())
+	}
+	return clientFor(cfg, key)
+}
+
+func clientFor(cfg *config.Config, key clientKey) *http.Client {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if client, ok := clients[key]; ok {
+		return client
+	}
+	tr := newTransport(cfg, lookupSourceAddr(key.sourceAddr))
+	if key.serverName != "" {
+		tr.TLSClientConfig.ServerName = key.serverName
+	}
+	client := newHTTPClient(cfg, tr)
+	clients[key] = client
+	transports = append(transports, tr)
+	return client
+}
+
+// 释放共用连接池中的空闲连接，扫描结束时调用
+func CloseIdleConnections() {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for _, tr := range transports {
+		tr.CloseIdleConnections()
+	}
+}
+
+func newTransport(cfg *config.Config, src *sourceAddr) *http.Transport {
+	maxIdleConns := cfg.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	maxIdleConnsPerHost := cfg.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	idleConnTimeout := cfg.IdleConnTimeout
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+
+	return &http.Transport{
+		// 经代理、自定义解析和出口地址绑定建立连接，TLS 仍由 Transport 在连接上完成
+		DialContext: limitedDial(newDialer(src, &net.Dialer{
 			Timeout:   time.Duration(cfg.TimeOut) * time.Second,
 			KeepAlive: 30 * time.Second,
-			DualStack: true, // 启用 Happy Eyeballs
-		}).DialContext,
+		})),
 		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
-		IdleConnTimeout:       10 * time.Second,
+		IdleConnTimeout:       time.Duration(idleConnTimeout) * time.Second,
 		TLSHandshakeTimeout:   5 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
-		MaxIdleConns:          200,
-		MaxIdleConnsPerHost:   20,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost, // 0 为不限制
 		DisableKeepAlives:     false,
 	}
+}
 
+func newHTTPClient(cfg *config.Config, tr http.RoundTripper) *http.Client {
 	checkRedirect := func(req *http.Request, via []*http.Request) error {
 		if len(via) >= 5 {
 			return fmt.Errorf("stopped after 5 redirects")
 		}
 		if len(via) > 0 {
 			lastRequest := via[len(via)-1]
-			if lastRequest.URL.Scheme == "http" && req.URL.Scheme == "https" {
+			if lastRequest.URL.Scheme == "http" && req.URL.Scheme == "https" && !httpsRedirectAllowed(req.Context()) {
 				logRedirectToHTTPS(lastRequest.URL.String(), req.URL.String())
 				return fmt.Errorf("redirected to HTTPS")
 			}
@@ -42,16 +120,23 @@ func CreateHTTPClient(cfg *config.Config) *http.Client {
 		return nil
 	}
 
-	client := &http.Client{
+	return &http.Client{
 		Timeout:       time.Duration(cfg.TimeOut) * time.Second,
-		Transport:     tr,
+		Transport:     &limitedTransport{base: tr},
 		CheckRedirect: checkRedirect,
 	}
+}
 
-	// 在程序退出时释放空闲连接
-	defer tr.CloseIdleConnections()
+type allowHTTPSRedirectKey struct{}
 
-	return client
+// 标记请求允许从 http 重定向到 https，auto 模式使用
+func withHTTPSRedirect(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allowHTTPSRedirectKey{}, true)
+}
+
+func httpsRedirectAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(allowHTTPSRedirectKey{}).(bool)
+	return allowed
 }
 
 // 创建并返回一个HTTP GET请求
@@ -0,0 +1,323 @@
+package network
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/qist/iptv-static-scan/output"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsNullPID    = 0x1fff
+	tsPATPID     = 0
+
+	pcrClock      = 27000000            // PCR 时钟 27MHz
+	pcrWrap       = (1 << 33) * 300     // PCR 回绕周期
+	maxPCRGap     = pcrClock / 10       // 相邻 PCR 最大间隔 100ms（ISO 13818-1）
+	defaultTSRate = 0.01                // 未配置时允许的最大错误率
+	tsSyncCheck   = 3                   // 连续几个包头都是 0x47 才认为找到同步
+	tsMaxResync   = tsPacketSize * 1024 // 开头最多跳过的字节数，超过时认为不是 TS 流
+)
+
+// TS 流类型对应的编码
+var tsStreamTypes = map[byte]struct {
+	codec string
+	video bool
+}{
+	0x01: {"MPEG-1", true},
+	0x02: {"MPEG-2", true},
+	0x10: {"MPEG-4", true},
+	0x1b: {"H.264", true},
+	0x24: {"H.265", true},
+	0x42: {"AVS", true},
+	0xd2: {"AVS2", true},
+	0x03: {"MP2", false},
+	0x04: {"MP2", false},
+	0x0f: {"AAC", false},
+	0x11: {"AAC-LATM", false},
+	0x81: {"AC-3", false},
+	0x87: {"E-AC-3", false},
+}
+
+// stream_type 为 0x06（私有数据）时按描述符判断音频编码
+var tsPrivateDescriptors = map[byte]string{
+	0x6a: "AC-3",
+	0x7a: "E-AC-3",
+	0x7b: "DTS",
+}
+
+// TSValidator 增量解析 MPEG-TS 数据，检查同步字节、PAT/PMT、PCR 和连续计数器
+type TSValidator struct {
+	buf     []byte // 不足一个包的剩余数据
+	synced  bool
+	skipped int // 找到同步前跳过的字节数
+
+	packets    int
+	syncErrors int
+	ccErrors   int
+	pcrErrors  int
+	teiErrors  int // 传输错误指示位
+
+	pmtPIDs  map[uint16]bool
+	pcrPID   int // -1 表示未知
+	lastCC   map[uint16]byte
+	lastPCR  int64 // -1 表示还没有 PCR
+	video    string
+	audio    []string
+	esPIDs   map[uint16]bool
+	foundPAT bool
+	foundPMT bool
+}
+
+// 创建 TS 校验器
+func NewTSValidator() *TSValidator {
+	return &TSValidator{
+		pmtPIDs: make(map[uint16]bool),
+		pcrPID:  -1,
+		lastCC:  make(map[uint16]byte),
+		lastPCR: -1,
+		esPIDs:  make(map[uint16]bool),
+	}
+}
+
+// 写入下载到的数据，不会返回错误
+func (v *TSValidator) Write(p []byte) (int, error) {
+	v.buf = append(v.buf, p...)
+	off := 0
+	for {
+		if !v.synced {
+			i := v.findSync(off)
+			if i < 0 {
+				// 保留最后一段数据，等待更多数据后继续查找
+				keep := tsPacketSize * tsSyncCheck
+				if len(v.buf)-off > keep {
+					v.skipped += len(v.buf) - off - keep
+					off = len(v.buf) - keep
+				}
+				break
+			}
+			v.skipped += i - off
+			if v.packets > 0 {
+				v.syncErrors++
+			}
+			off = i
+			v.synced = true
+		}
+		if len(v.buf)-off < tsPacketSize {
+			break
+		}
+		if v.buf[off] != tsSyncByte {
+			v.synced = false
+			v.skipped++
+			off++
+			continue
+		}
+		v.packet(v.buf[off : off+tsPacketSize])
+		off += tsPacketSize
+	}
+	v.buf = append(v.buf[:0], v.buf[off:]...)
+	return len(p), nil
+}
+
+// 从 off 开始查找连续 tsSyncCheck 个包头都是同步字节的位置
+func (v *TSValidator) findSync(off int) int {
+	for i := off; i+tsPacketSize*(tsSyncCheck-1) < len(v.buf); i++ {
+		ok := true
+		for k := 0; k < tsSyncCheck; k++ {
+			if v.buf[i+k*tsPacketSize] != tsSyncByte {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return i
+		}
+	}
+	return -1
+}
+
+func (v *TSValidator) packet(pkt []byte) {
+	v.packets++
+	if pkt[1]&0x80 != 0 {
+		v.teiErrors++
+		return
+	}
+	pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+	if pid == tsNullPID {
+		return
+	}
+	pusi := pkt[1]&0x40 != 0
+	afc := (pkt[3] >> 4) & 0x3
+	cc := pkt[3] & 0xf
+
+	payload := 4
+	discontinuity := false
+	if afc&0x2 != 0 {
+		afLen := int(pkt[4])
+		payload = 5 + afLen
+		if afLen > 0 && payload <= tsPacketSize {
+			flags := pkt[5]
+			discontinuity = flags&0x80 != 0
+			if flags&0x10 != 0 && afLen >= 7 && int(pid) == v.pcrPID {
+				v.checkPCR(pkt[6:12], discontinuity)
+			}
+		}
+	}
+
+	// 只有带负载的包连续计数器才递增，重复包允许出现一次
+	if afc&0x1 != 0 {
+		if last, ok := v.lastCC[pid]; ok && !discontinuity && cc != (last+1)&0xf && cc != last {
+			v.ccErrors++
+		}
+		v.lastCC[pid] = cc
+	}
+
+	if afc&0x1 == 0 || payload >= tsPacketSize || !pusi {
+		return
+	}
+	switch {
+	case pid == tsPATPID:
+		v.parsePAT(pkt[payload:])
+	case v.pmtPIDs[pid]:
+		v.parsePMT(pkt[payload:])
+	}
+}
+
+// 检查 PCR 是否倒退或间隔过大
+func (v *TSValidator) checkPCR(b []byte, discontinuity bool) {
+	base := int64(b[0])<<25 | int64(b[1])<<17 | int64(b[2])<<9 | int64(b[3])<<1 | int64(b[4])>>7
+	ext := int64(b[4]&0x1)<<8 | int64(b[5])
+	pcr := base*300 + ext
+	if v.lastPCR >= 0 && !discontinuity {
+		delta := pcr - v.lastPCR
+		if delta < -pcrWrap/2 {
+			delta += pcrWrap // 回绕
+		}
+		if delta < 0 || delta > maxPCRGap {
+			v.pcrErrors++
+		}
+	}
+	v.lastPCR = pcr
+}
+
+// 返回 PSI 段内容（去掉 pointer_field），长度不足时返回 nil
+func psiSection(payload []byte, tableID byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil
+	}
+	sec := payload[1+pointer:]
+	if sec[0] != tableID {
+		return nil
+	}
+	length := int(sec[1]&0x0f)<<8 | int(sec[2])
+	if 3+length > len(sec) || length < 9 {
+		return nil // 跨包的段不解析
+	}
+	// 去掉段头、CRC
+	return sec[:3+length-4]
+}
+
+func (v *TSValidator) parsePAT(payload []byte) {
+	sec := psiSection(payload, 0x00)
+	if sec == nil {
+		return
+	}
+	v.foundPAT = true
+	for i := 8; i+4 <= len(sec); i += 4 {
+		program := uint16(sec[i])<<8 | uint16(sec[i+1])
+		pid := uint16(sec[i+2]&0x1f)<<8 | uint16(sec[i+3])
+		if program != 0 {
+			v.pmtPIDs[pid] = true
+		}
+	}
+}
+
+func (v *TSValidator) parsePMT(payload []byte) {
+	sec := psiSection(payload, 0x02)
+	if sec == nil || len(sec) < 12 {
+		return
+	}
+	v.foundPMT = true
+	v.pcrPID = int(sec[8]&0x1f)<<8 | int(sec[9])
+	infoLen := int(sec[10]&0x0f)<<8 | int(sec[11])
+	for i := 12 + infoLen; i+5 <= len(sec); {
+		streamType := sec[i]
+		pid := uint16(sec[i+1]&0x1f)<<8 | uint16(sec[i+2])
+		esInfoLen := int(sec[i+3]&0x0f)<<8 | int(sec[i+4])
+		end := i + 5 + esInfoLen
+		if end > len(sec) {
+			break
+		}
+		if !v.esPIDs[pid] {
+			v.esPIDs[pid] = true
+			v.addStream(streamType, sec[i+5:end])
+		}
+		i = end
+	}
+}
+
+func (v *TSValidator) addStream(streamType byte, descriptors []byte) {
+	if st, ok := tsStreamTypes[streamType]; ok {
+		if st.video {
+			if v.video == "" {
+				v.video = st.codec
+			}
+		} else {
+			v.audio = append(v.audio, st.codec)
+		}
+		return
+	}
+	if streamType != 0x06 {
+		return
+	}
+	for i := 0; i+2 <= len(descriptors); i += 2 + int(descriptors[i+1]) {
+		if codec, ok := tsPrivateDescriptors[descriptors[i]]; ok {
+			v.audio = append(v.audio, codec)
+			return
+		}
+	}
+}
+
+// 返回解析结果，没有找到同步字节时返回 nil
+func (v *TSValidator) Info() *output.StreamInfo {
+	if v.packets == 0 {
+		return nil
+	}
+	errors := v.syncErrors + v.ccErrors + v.pcrErrors + v.teiErrors
+	return &output.StreamInfo{
+		Format:      "mpegts",
+		VideoCodec:  v.video,
+		AudioCodecs: v.audio,
+		Packets:     v.packets,
+		SyncErrors:  v.syncErrors,
+		CCErrors:    v.ccErrors,
+		PCRErrors:   v.pcrErrors,
+		TEIErrors:   v.teiErrors,
+		ErrorRate:   math.Round(float64(errors)/float64(v.packets)*10000) / 10000,
+	}
+}
+
+// 判断流是否合格，maxErrorRate 小于等于 0 时使用默认值，不合格时返回原因
+func (v *TSValidator) Check(maxErrorRate float64) error {
+	if maxErrorRate <= 0 {
+		maxErrorRate = defaultTSRate
+	}
+	if v.packets == 0 {
+		return fmt.Errorf("不是 MPEG-TS 流，未找到同步字节")
+	}
+	if v.skipped > tsMaxResync {
+		return fmt.Errorf("开头有 %d 字节不是 TS 数据", v.skipped)
+	}
+	info := v.Info()
+	if info.ErrorRate > maxErrorRate {
+		return fmt.Errorf("错误率 %.2f%% 超过 %.2f%%（同步 %d, 连续计数 %d, PCR %d, 传输错误 %d）",
+			info.ErrorRate*100, maxErrorRate*100, v.syncErrors, v.ccErrors, v.pcrErrors, v.teiErrors)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package network
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// tsPkt 测试用的 TS 包
+type tsPkt struct {
+	pid       uint16
+	cc        byte
+	pusi      bool
+	payload   []byte
+	pcr       int64 // 小于 0 时不带 PCR
+	disc      bool  // discontinuity_indicator
+	noPayload bool  // 只有自适应字段
+	tei       bool
+}
+
+func (p tsPkt) bytes() []byte {
+	pkt := bytes.Repeat([]byte{0xff}, tsPacketSize)
+	pkt[0] = tsSyncByte
+	pkt[1] = byte(p.pid>>8) & 0x1f
+	if p.pusi {
+		pkt[1] |= 0x40
+	}
+	if p.tei {
+		pkt[1] |= 0x80
+	}
+	pkt[2] = byte(p.pid)
+	afc := byte(0x1)
+	if p.noPayload {
+		afc = 0x2
+	}
+	off := 4
+	if p.pcr >= 0 || p.disc || p.noPayload {
+		afc |= 0x2
+		var flags byte
+		if p.disc {
+			flags |= 0x80
+		}
+		af := []byte{flags}
+		if p.pcr >= 0 {
+			af[0] |= 0x10
+			base, ext := p.pcr/300, p.pcr%300
+			af = append(af,
+				byte(base>>25), byte(base>>17), byte(base>>9), byte(base>>1),
+				byte(base&1)<<7|0x7e|byte(ext>>8), byte(ext))
+		}
+		pkt[4] = byte(len(af))
+		copy(pkt[5:], af)
+		off = 5 + len(af)
+	}
+	pkt[3] = afc<<4 | p.cc&0xf
+	if !p.noPayload {
+		copy(pkt[off:], p.payload)
+	}
+	return pkt
+}
+
+// 简单的负载包
+func esPkt(pid uint16, cc byte) tsPkt {
+	return tsPkt{pid: pid, cc: cc, pcr: -1}
+}
+
+// 带 PCR 的负载包
+func pcrPkt(pid uint16, cc byte, pcr int64, disc bool) tsPkt {
+	return tsPkt{pid: pid, cc: cc, pcr: pcr, disc: disc}
+}
+
+// PAT，节目 1 的 PMT 在 pmtPID
+func patPkt(pmtPID uint16) tsPkt {
+	sec := []byte{0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0,
+		0, 1, 0xe0 | byte(pmtPID>>8), byte(pmtPID),
+		0, 0, 0, 0}
+	return tsPkt{pid: tsPATPID, pusi: true, payload: append([]byte{0}, sec...), pcr: -1}
+}
+
+// PMT，streams 为 stream_type、PID 和描述符
+type pmtStream struct {
+	typ         byte
+	pid         uint16
+	descriptors []byte
+}
+
+func pmtPkt(pmtPID, pcrPID uint16, streams ...pmtStream) tsPkt {
+	var body []byte
+	for _, s := range streams {
+		body = append(body, s.typ, 0xe0|byte(s.pid>>8), byte(s.pid), 0xf0, byte(len(s.descriptors)))
+		body = append(body, s.descriptors...)
+	}
+	length := 9 + len(body) + 4
+	sec := []byte{0x02, 0xb0, byte(length), 0, 1, 0xc1, 0, 0, 0xe0 | byte(pcrPID>>8), byte(pcrPID), 0xf0, 0}
+	sec = append(sec, body...)
+	sec = append(sec, 0, 0, 0, 0)
+	return tsPkt{pid: pmtPID, pusi: true, payload: append([]byte{0}, sec...), pcr: -1}
+}
+
+func joinPkts(pkts ...tsPkt) []byte {
+	var b []byte
+	for _, p := range pkts {
+		b = append(b, p.bytes()...)
+	}
+	return b
+}
+
+// 节目信息：H.264 视频兼 PCR，AAC 音频和私有数据中的 AC-3 音频
+var tsProgram = []tsPkt{
+	patPkt(0x100),
+	pmtPkt(0x100, 0x101,
+		pmtStream{typ: 0x1b, pid: 0x101},
+		pmtStream{typ: 0x0f, pid: 0x102},
+		pmtStream{typ: 0x06, pid: 0x103, descriptors: []byte{0x0a, 4, 'c', 'h', 'i', 0, 0x6a, 1, 0}}),
+}
+
+func TestTSValidator(t *testing.T) {
+	const ms = pcrClock / 1000
+	tests := []struct {
+		name    string
+		data    []byte
+		skipped int
+		sync    int
+		cc      int
+		pcr     int
+		tei     int
+	}{
+		{
+			name: "clean",
+			data: joinPkts(esPkt(0x101, 0), esPkt(0x101, 1), esPkt(0x101, 2), esPkt(0x102, 7), esPkt(0x102, 8)),
+		},
+		{
+			name:    "leading garbage",
+			data:    append([]byte("<html>error</html>"), joinPkts(esPkt(0x101, 0), esPkt(0x101, 1), esPkt(0x101, 2))...),
+			skipped: len("<html>error</html>"),
+		},
+		{
+			name: "resync after lost bytes",
+			data: slices.Concat(
+				joinPkts(esPkt(0x101, 0), esPkt(0x101, 1), esPkt(0x101, 2)),
+				[]byte{1, 2, 3, 4, 5},
+				joinPkts(esPkt(0x101, 3), esPkt(0x101, 4), esPkt(0x101, 5))),
+			skipped: 5,
+			sync:    1,
+		},
+		{
+			name: "cc duplicate allowed once",
+			data: joinPkts(esPkt(0x101, 0), esPkt(0x101, 1), esPkt(0x101, 1), esPkt(0x101, 2)),
+		},
+		{
+			name: "cc wraps",
+			data: joinPkts(esPkt(0x101, 14), esPkt(0x101, 15), esPkt(0x101, 0), esPkt(0x101, 1)),
+		},
+		{
+			name: "cc gap",
+			data: joinPkts(esPkt(0x101, 0), esPkt(0x101, 1), esPkt(0x101, 3), esPkt(0x101, 4)),
+			cc:   1,
+		},
+		{
+			name: "cc per pid",
+			data: joinPkts(esPkt(0x101, 0), esPkt(0x102, 5), esPkt(0x101, 1), esPkt(0x102, 9), esPkt(0x101, 2)),
+			cc:   1,
+		},
+		{
+			name: "cc discontinuity flag",
+			data: joinPkts(esPkt(0x101, 0), esPkt(0x101, 1), tsPkt{pid: 0x101, cc: 9, pcr: -1, disc: true}, esPkt(0x101, 10)),
+		},
+		{
+			name: "cc adaptation only packet does not advance",
+			data: joinPkts(esPkt(0x101, 0), tsPkt{pid: 0x101, cc: 0, pcr: -1, noPayload: true}, esPkt(0x101, 1)),
+		},
+		{
+			name: "null packets ignored",
+			data: joinPkts(esPkt(0x101, 0), esPkt(tsNullPID, 7), esPkt(tsNullPID, 3), esPkt(0x101, 1)),
+		},
+		{
+			name: "transport error",
+			data: joinPkts(esPkt(0x101, 0), tsPkt{pid: 0x101, cc: 1, pcr: -1, tei: true}, esPkt(0x101, 1)),
+			tei:  1,
+		},
+		{
+			name: "pcr within 100ms",
+			data: joinPkts(append(slices.Clone(tsProgram),
+				pcrPkt(0x101, 0, 1000*ms, false), pcrPkt(0x101, 1, 1040*ms, false), pcrPkt(0x101, 2, 1080*ms, false))...),
+		},
+		{
+			name: "pcr gap",
+			data: joinPkts(append(slices.Clone(tsProgram),
+				pcrPkt(0x101, 0, 1000*ms, false), pcrPkt(0x101, 1, 1300*ms, false))...),
+			pcr: 1,
+		},
+		{
+			name: "pcr backwards",
+			data: joinPkts(append(slices.Clone(tsProgram),
+				pcrPkt(0x101, 0, 1000*ms, false), pcrPkt(0x101, 1, 990*ms, false))...),
+			pcr: 1,
+		},
+		{
+			name: "pcr wrap",
+			data: joinPkts(append(slices.Clone(tsProgram),
+				pcrPkt(0x101, 0, pcrWrap-20*ms, false), pcrPkt(0x101, 1, 20*ms, false))...),
+		},
+		{
+			name: "pcr discontinuity",
+			data: joinPkts(append(slices.Clone(tsProgram),
+				pcrPkt(0x101, 0, 1000*ms, false), pcrPkt(0x101, 1, 90000*ms, true), pcrPkt(0x101, 2, 90040*ms, false))...),
+		},
+		{
+			name: "pcr on other pid ignored",
+			data: joinPkts(append(slices.Clone(tsProgram),
+				pcrPkt(0x102, 0, 1000*ms, false), pcrPkt(0x102, 1, 9000*ms, false))...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 整块写入和逐字节写入的结果应相同
+			for _, step := range []int{len(tt.data), 1, 100} {
+				v := NewTSValidator()
+				for i := 0; i < len(tt.data); i += step {
+					v.Write(tt.data[i:min(i+step, len(tt.data))])
+				}
+				if v.skipped != tt.skipped || v.syncErrors != tt.sync || v.ccErrors != tt.cc || v.pcrErrors != tt.pcr || v.teiErrors != tt.tei {
+					t.Errorf("step %d: skipped=%d sync=%d cc=%d pcr=%d tei=%d, want %d %d %d %d %d", step,
+						v.skipped, v.syncErrors, v.ccErrors, v.pcrErrors, v.teiErrors,
+						tt.skipped, tt.sync, tt.cc, tt.pcr, tt.tei)
+				}
+			}
+		})
+	}
+}
+
+func TestTSValidatorCodecs(t *testing.T) {
+	v := NewTSValidator()
+	// PMT 重复出现时不重复记录编码
+	v.Write(joinPkts(append(slices.Clone(tsProgram), esPkt(0x101, 0), tsProgram[1], esPkt(0x101, 1))...))
+	info := v.Info()
+	if info == nil {
+		t.Fatal("Info() = nil")
+	}
+	if info.Format != "mpegts" || info.VideoCodec != "H.264" {
+		t.Errorf("format=%q video=%q, want mpegts H.264", info.Format, info.VideoCodec)
+	}
+	if want := []string{"AAC", "AC-3"}; !slices.Equal(info.AudioCodecs, want) {
+		t.Errorf("audio=%v, want %v", info.AudioCodecs, want)
+	}
+	if v.pcrPID != 0x101 {
+		t.Errorf("pcrPID=%#x, want 0x101", v.pcrPID)
+	}
+	if !v.foundPAT || !v.foundPMT {
+		t.Errorf("foundPAT=%v foundPMT=%v", v.foundPAT, v.foundPMT)
+	}
+}
+
+func TestTSValidatorCheck(t *testing.T) {
+	clean := make([]tsPkt, 0, 200)
+	for i := range 200 {
+		clean = append(clean, esPkt(0x101, byte(i)))
+	}
+	// 200 个包中 4 个连续计数器错误，错误率 2%
+	broken := slices.Clone(clean)
+	for _, i := range []int{20, 60, 100, 140} {
+		broken[i].cc += 5
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		maxRate float64
+		wantErr bool
+	}{
+		{"clean", joinPkts(clean...), 0, false},
+		{"html", bytes.Repeat([]byte("<html><body>404 Not Found</body></html>\n"), 100), 0, true},
+		{"too much garbage", append(bytes.Repeat([]byte{0}, tsMaxResync+1), joinPkts(clean...)...), 0, true},
+		{"error rate above default", joinPkts(broken...), 0, true},
+		{"error rate within custom limit", joinPkts(broken...), 0.1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewTSValidator()
+			v.Write(tt.data)
+			if err := v.Check(tt.maxRate); (err != nil) != tt.wantErr {
+				t.Errorf("Check() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
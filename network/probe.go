@@ -0,0 +1,131 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/qist/iptv-static-scan/config"
+)
+
+// 文本类响应（m3u8、网页、json）最多读取的字节数
+const maxTextBodySize = 1 << 20
+
+// 内容类型嗅探读取的前缀长度，两个 TS 包以上
+const sniffSize = 512
+
+// 关闭响应前最多丢弃的剩余字节数，超过时直接断开连接
+const drainSize = 4 << 10
+
+// Probe 一次 HTTP 请求的响应
+// 响应头和已读取的响应体前缀在各检测阶段之间共用，同一目标只请求一次
+type Probe struct {
+	Target  *Target
+	URL     string
+	Resp    *http.Response
+	Start   time.Time     // 发起请求的时间
+	Latency time.Duration // 收到响应头的耗时
+	prefix  []byte        // 已读取的响应体前缀
+	readErr error         // 读取前缀时遇到的错误
+	closed  bool
+}
+
+// 请求目标并返回响应，调用方负责 Close
+// auto 协议先请求 http，连接可用但不是 HTTP 服务（或返回 400）时再请求 https，
+// 返回的 Probe.Target 为实际使用的协议
+func Fetch(t *Target, cfg *config.Config) (*Probe, error) {
+	if t.Scheme != SchemeAuto {
+		return fetch(t, cfg, false)
+	}
+	p, err := fetch(t.WithScheme(SchemeHTTP), cfg, true)
+	if err == nil {
+		if p.Resp.StatusCode != http.StatusBadRequest {
+			return p, nil
+		}
+		// nginx 等对发到 https 端口的明文请求返回 400
+		p.Close()
+	} else if !retryWithHTTPS(err) {
+		return nil, err
+	}
+	log.Printf("%s 改用 https 重试\n", t.URL())
+	return fetch(t.WithScheme(SchemeHTTPS), cfg, false)
+}
+
+// allowHTTPS 为 true 时允许 http 重定向到 https
+func fetch(t *Target, cfg *config.Config, allowHTTPS bool) (*Probe, error) {
+	url := t.URL()
+	client := clientForTarget(t, cfg)  // 复用创建HTTP客户端的代码
+	req, err := CreateHTTPRequest(url) // 复用创建HTTP请求的代码
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header = http.Header(cfg.UAHeaders).Clone() // 设置请求头
+	if t.Host != "" {
+		req.Host = t.Host
+	}
+	if allowHTTPS {
+		req = req.WithContext(withHTTPSRedirect(req.Context()))
+	}
+
+	start := time.Now()
+	resp, err := Do(client, req)
+	if err != nil {
+		return nil, err
+	}
+	return &Probe{
+		Target:  t,
+		URL:     url,
+		Resp:    resp,
+		Start:   start,
+		Latency: time.Since(start),
+	}, nil
+}
+
+// 读取并缓存最多 n 字节的响应体前缀，可重复调用
+func (p *Probe) Peek(n int) ([]byte, error) {
+	if len(p.prefix) < n && p.readErr == nil {
+		buf := make([]byte, n-len(p.prefix))
+		m, err := io.ReadFull(p.Resp.Body, buf)
+		p.prefix = append(p.prefix, buf[:m]...)
+		if err != nil {
+			p.readErr = err
+		}
+	}
+	if len(p.prefix) > n {
+		return p.prefix[:n], nil
+	}
+	if p.readErr == io.EOF || p.readErr == io.ErrUnexpectedEOF {
+		return p.prefix, nil
+	}
+	return p.prefix, p.readErr
+}
+
+// 读取完整的文本响应体，最多 maxTextBodySize 字节
+func (p *Probe) ReadText() ([]byte, error) {
+	return p.Peek(maxTextBodySize)
+}
+
+// 返回从头开始的响应体，已读取的前缀会先返回
+func (p *Probe) Body() io.Reader {
+	if len(p.prefix) == 0 {
+		return p.Resp.Body
+	}
+	return io.MultiReader(bytes.NewReader(p.prefix), p.Resp.Body)
+}
+
+// 关闭响应体
+// 先丢弃最多 drainSize 字节的剩余内容，较小的响应读完后连接可以放回连接池复用
+// 可以重复调用
+func (p *Probe) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.readErr == nil {
+		io.CopyN(io.Discard, p.Resp.Body, drainSize)
+	}
+	return p.Resp.Body.Close()
+}
@@ -0,0 +1,25 @@
+package network
+
+import (
+	"time"
+
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/proxy"
+	"github.com/qist/iptv-static-scan/resolver"
+)
+
+// 上游代理池，未配置时为 nil，直接连接目标
+var proxyPool *proxy.Pool
+
+// 根据配置初始化上游代理，需在发起任何请求之前调用
+func InitProxy(cfg *config.Config) error {
+	pool, err := proxy.New(cfg.Proxies, cfg.ProxyMaxFails,
+		time.Duration(cfg.ProxyCooldown)*time.Second, time.Duration(cfg.TimeOut)*time.Second)
+	if err != nil {
+		return err
+	}
+	// socks5:// 代理在本地解析目标域名，与直连使用同一个解析器
+	pool.SetLookup(resolver.LookupIP)
+	proxyPool = pool
+	return nil
+}
@@ -0,0 +1,53 @@
+package network
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/ratelimit"
+)
+
+var (
+	requestLimiter *ratelimit.Limiter // 全局请求限速
+	connLimiter    *ratelimit.Limiter // 新建连接限速
+)
+
+// 根据配置初始化全局限速，未配置时不限速
+func InitRateLimiter(cfg *config.Config) {
+	requestLimiter = ratelimit.New(cfg.RateLimit, cfg.RateBurst)
+	connLimiter = ratelimit.New(cfg.ConnRateLimit, cfg.ConnRateBurst)
+}
+
+// 获取请求令牌后发送请求，等待令牌的时间不计入请求超时
+func Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if err := requestLimiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// limitedTransport 重定向产生的后续请求同样需要获取请求令牌
+type limitedTransport struct {
+	base http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Response != nil {
+		if err := requestLimiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// 新建连接前先获取连接令牌
+func limitedDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if err := connLimiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		return dial(ctx, network, addr)
+	}
+}
@@ -0,0 +1,32 @@
+package network
+
+import (
+	"time"
+
+	"github.com/qist/iptv-static-scan/output"
+)
+
+// 根据响应生成命中结果，服务软件由调用方在关闭连接后识别
+func newResult(p *Probe, kind string, duration time.Duration, speed *float64, bytesRead int64) *output.Result {
+	t := p.Target
+	return &output.Result{
+		IP:          t.IP,
+		Port:        t.Port,
+		Path:        t.Path,
+		URL:         t.URL(),
+		Scheme:      t.scheme(),
+		Vhost:       t.Vhost,
+		Domain:      t.Domain,
+		SourceAddr:  t.SourceAddr,
+		Source:      t.Source,
+		Kind:        kind,
+		Label:       t.Label,
+		Server:      p.Resp.Header.Get("Server"),
+		ContentType: p.Resp.Header.Get("Content-Type"),
+		StatusCode:  p.Resp.StatusCode,
+		Latency:     duration,
+		Speed:       speed,
+		BytesRead:   bytesRead,
+		Timestamp:   time.Now(),
+	}
+}
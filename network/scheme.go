@@ -0,0 +1,120 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/util"
+)
+
+// 目标协议
+const (
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+	SchemeAuto  = "auto" // 先请求 http，失败时再请求 https，并允许 http 重定向到 https
+)
+
+// 按端口和路径选择协议的规则，首次使用时根据配置生成
+type schemeRules struct {
+	defaultScheme string
+	ports         map[int]string
+	paths         map[string]string
+}
+
+var (
+	schemeRulesCache *schemeRules
+	schemeRulesOnce  sync.Once
+)
+
+func loadSchemeRules(cfg *config.Config) *schemeRules {
+	schemeRulesOnce.Do(func() {
+		schemeRulesCache = &schemeRules{
+			defaultScheme: normalizeScheme(cfg.Scheme),
+			ports:         make(map[int]string),
+			paths:         make(map[string]string),
+		}
+		for portRange, scheme := range cfg.PortSchemes {
+			for _, port := range util.ExpandPorts([]string{portRange}) {
+				schemeRulesCache.ports[port] = normalizeScheme(scheme)
+			}
+		}
+		for urlPath, scheme := range cfg.PathSchemes {
+			schemeRulesCache.paths[strings.TrimPrefix(urlPath, "/")] = normalizeScheme(scheme)
+		}
+	})
+	return schemeRulesCache
+}
+
+func normalizeScheme(scheme string) string {
+	switch strings.ToLower(strings.TrimSpace(scheme)) {
+	case SchemeHTTPS:
+		return SchemeHTTPS
+	case SchemeAuto:
+		return SchemeAuto
+	default:
+		return SchemeHTTP
+	}
+}
+
+// 返回目标应使用的协议：路径规则优先（最长前缀匹配），其次端口规则，最后是全局 scheme
+func SchemeFor(cfg *config.Config, port int, urlPath string) string {
+	r := loadSchemeRules(cfg)
+	urlPath = strings.TrimPrefix(urlPath, "/")
+	matched := -1
+	scheme := ""
+	for prefix, s := range r.paths {
+		if len(prefix) > matched && strings.HasPrefix(urlPath, prefix) {
+			matched = len(prefix)
+			scheme = s
+		}
+	}
+	if scheme != "" {
+		return scheme
+	}
+	if s, ok := r.ports[port]; ok {
+		return s
+	}
+	return r.defaultScheme
+}
+
+// 按配置补全目标的协议、Host 和 SNI，已设置的字段保持不变
+func (t *Target) ApplyConfig(cfg *config.Config) {
+	if t.Scheme == "" {
+		t.Scheme = SchemeFor(cfg, t.Port, t.Path)
+	}
+	if t.Host == "" {
+		t.Host = cfg.HostHeader
+	}
+	if t.ServerName == "" {
+		t.ServerName = cfg.TLSServerName
+	}
+}
+
+// auto 模式下 http 请求失败后是否值得再试 https
+// 超时和连接被拒绝说明端口本身不可用，换协议也没有意义
+func retryWithHTTPS(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, syscall.ECONNREFUSED) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	return true
+}
+
+// 去掉 Host 中的端口，用作 SNI
+func hostWithoutPort(host string) string {
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if _, err := strconv.Atoi(port); err == nil {
+			return h
+		}
+	}
+	return strings.Trim(host, "[]")
+}
@@ -0,0 +1,122 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/qist/iptv-static-scan/config"
+)
+
+// sourceAddr 一个本地出口地址，可以是 IP 或网卡名
+type sourceAddr struct {
+	name string
+	v4   net.IP // 连接 IPv4 目标时绑定的地址
+	v6   net.IP // 连接 IPv6 目标时绑定的地址
+}
+
+var (
+	sourceAddrs []*sourceAddr // 未配置时为空，由系统选择出口地址
+	sourceIndex uint64
+)
+
+// 根据配置初始化本地出口地址，网卡名会解析为该网卡上的 IPv4 和 IPv6 地址
+func InitSourceAddrs(cfg *config.Config) error {
+	sourceAddrs = nil
+	for _, name := range cfg.SourceAddrs {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		src, err := parseSourceAddr(name)
+		if err != nil {
+			return err
+		}
+		sourceAddrs = append(sourceAddrs, src)
+	}
+	return nil
+}
+
+func parseSourceAddr(name string) (*sourceAddr, error) {
+	src := &sourceAddr{name: name}
+	if ip := net.ParseIP(strings.Trim(name, "[]")); ip != nil {
+		if ip.To4() != nil {
+			src.v4 = ip
+		} else {
+			src.v6 = ip
+		}
+		return src, nil
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("无效的出口地址或网卡 %s: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("读取网卡 %s 地址失败: %v", name, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			if src.v4 == nil {
+				src.v4 = ipNet.IP
+			}
+		} else if src.v6 == nil {
+			src.v6 = ipNet.IP
+		}
+	}
+	if src.v4 == nil && src.v6 == nil {
+		return nil, fmt.Errorf("网卡 %s 没有可用的地址", name)
+	}
+	return src, nil
+}
+
+// 轮流返回下一个出口地址，未配置时返回空字符串
+// 同一个目标 IP 的预扫描和所有请求应使用同一个出口地址
+func NextSourceAddr() string {
+	if len(sourceAddrs) == 0 {
+		return ""
+	}
+	i := atomic.AddUint64(&sourceIndex, 1) - 1
+	return sourceAddrs[i%uint64(len(sourceAddrs))].name
+}
+
+func lookupSourceAddr(name string) *sourceAddr {
+	for _, src := range sourceAddrs {
+		if src.name == name {
+			return src
+		}
+	}
+	return nil
+}
+
+// 返回绑定出口地址的拨号函数，按连接目标的地址族选择本地地址
+// 目标为域名时优先使用 IPv4，由 Dialer 只连接与本地地址同族的解析结果
+func (s *sourceAddr) dialContext(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if s == nil {
+		return d.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		local := s.v4
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+				local = s.v6
+			}
+		}
+		if local == nil {
+			local = s.v6
+			if local == nil {
+				local = s.v4
+			}
+		}
+		bound := *d
+		bound.LocalAddr = &net.TCPAddr{IP: local}
+		return bound.DialContext(ctx, network, addr)
+	}
+}
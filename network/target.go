@@ -0,0 +1,80 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/qist/iptv-static-scan/config"
+)
+
+// Target 一个探测目标
+type Target struct {
+	IP         string // IP 地址或域名，IPv6 地址带方括号
+	Port       int
+	Path       string
+	Scheme     string // http、https 或 auto，为空时按 http 处理
+	Host       string // 覆盖请求的 Host 头，为空时使用 IP:端口
+	ServerName string // 覆盖 TLS 握手的 SNI，为空时使用 Host
+	Vhost      string // 本次探测使用的虚拟主机，见 VhostTargets
+	SourceAddr string // 绑定的本地出口地址，为空时由系统选择
+	Domain     string // 由域名展开得到的 IP 目标所属的域名
+	Source     string // 来源，CIDR 文件中的原始行
+	Label      string // 命中的检测规则的标签，写入结果
+
+	encrypted bool // m3u8 中 AES-128 整段加密的分片，下载时不按 TS 解析
+}
+
+// 返回目标的完整 URL
+func (t *Target) URL() string {
+	return fmt.Sprintf("%s://%s:%d/%s", t.scheme(), t.IP, t.Port, t.Path)
+}
+
+// 复制目标并替换路径
+func (t *Target) WithPath(urlPath string) *Target {
+	nt := *t
+	nt.Path = urlPath
+	return &nt
+}
+
+// 复制目标并替换协议
+func (t *Target) WithScheme(scheme string) *Target {
+	nt := *t
+	nt.Scheme = scheme
+	return &nt
+}
+
+// 实际使用的协议，auto 尚未确定时按 http 处理
+func (t *Target) scheme() string {
+	if t.Scheme == SchemeHTTPS {
+		return SchemeHTTPS
+	}
+	return SchemeHTTP
+}
+
+// TLS 握手使用的 SNI
+func (t *Target) serverName() string {
+	if t.ServerName != "" {
+		return t.ServerName
+	}
+	return t.Host
+}
+
+// 按配置的 vhosts 展开目标，每个虚拟主机作为 Host 头各探测一次
+// 只对 IP 目标展开，域名目标和域名展开出的 IP 本身就带有 Host；vhosts 中的空字符串表示不带虚拟主机直接探测 IP
+func VhostTargets(t *Target, cfg *config.Config) []*Target {
+	if len(cfg.Vhosts) == 0 || t.Domain != "" || net.ParseIP(strings.Trim(t.IP, "[]")) == nil {
+		return []*Target{t}
+	}
+	targets := make([]*Target, 0, len(cfg.Vhosts))
+	for _, vhost := range cfg.Vhosts {
+		vhost = strings.TrimSpace(vhost)
+		nt := *t
+		if vhost != "" {
+			nt.Host = vhost
+			nt.Vhost = vhost
+		}
+		targets = append(targets, &nt)
+	}
+	return targets
+}
@@ -0,0 +1,30 @@
+package network
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 通过 TCP 连接判断端口是否开放，只建立连接不发送数据，source 为绑定的出口地址
+func TCPPortOpen(ip string, port int, source string, timeout time.Duration) bool {
+	host := strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	// 与 HTTP 请求共用新建连接限速，等待令牌的时间不计入连接超时
+	if err := connLimiter.Wait(context.Background()); err != nil {
+		return false
+	}
+	// 配置了代理时经代理连接，代理能建立隧道即认为端口开放
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	dial := newDialer(lookupSourceAddr(source), &net.Dialer{})
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
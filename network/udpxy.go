@@ -0,0 +1,205 @@
+package network
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/output"
+)
+
+// udpxy 状态页路径，旧版本只有 /stat
+var udpxyStatusPaths = []string{"status", "stat"}
+
+var (
+	trRe        = regexp.MustCompile(`(?is)<tr[^>]*>(.*?)</tr>`)
+	cellRe      = regexp.MustCompile(`(?is)<(t[hd])[^>]*>(.*?)</t[hd]>`)
+	tagRe       = regexp.MustCompile(`(?s)<[^>]*>`)
+	numberRe    = regexp.MustCompile(`\d+`)
+	throughRe   = regexp.MustCompile(`(?i)([\d.]+)\s*([kmg]?)b(?:it)?/s`)
+	pageVerRe   = regexp.MustCompile(`(?i)udpxy\s+v\.?\s*(\d[\w.\-]*)`)
+	serverVerRe = regexp.MustCompile(`(?i)udpxy\s+(\d[\w.\-]*)`)
+)
+
+// 状态页的缓存时间，组播扫描等短时间内的多次命中共用一次请求，过期后重新请求以获得当前的客户端数
+const udpxyStatusTTL = 10 * time.Second
+
+// 每台 udpxy 的状态缓存，请求失败时不缓存，下次命中时重新请求
+type udpxyEntry struct {
+	mu            sync.Mutex
+	info          *output.UdpxyInfo
+	fetched       time.Time
+	serverVersion string // Server 头中的版本号，状态页中没有版本号时使用
+}
+
+var udpxyCache sync.Map
+
+// 返回 udpxy 的状态信息，未开启 udpxyStatus 时返回 nil
+// 状态页请求失败时只返回 Server 头中的版本号，server 为空时沿用之前命中记录的版本号
+func UdpxyStatus(t *Target, server string, cfg *config.Config) *output.UdpxyInfo {
+	if !cfg.UdpxyStatus {
+		return nil
+	}
+	key := fmt.Sprintf("%s://%s:%d|%s", t.scheme(), t.IP, t.Port, t.Host)
+	v, _ := udpxyCache.LoadOrStore(key, &udpxyEntry{})
+	e := v.(*udpxyEntry)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if m := serverVerRe.FindStringSubmatch(server); m != nil {
+		e.serverVersion = m[1]
+	}
+	if e.info == nil || time.Since(e.fetched) > udpxyStatusTTL {
+		if info := fetchUdpxyStatus(t, cfg); info != nil {
+			e.info, e.fetched = info, time.Now()
+		}
+	}
+	// 返回副本，之后刷新缓存不影响已生成的结果
+	info := &output.UdpxyInfo{}
+	if e.info != nil {
+		*info = *e.info
+	}
+	if info.Version == "" {
+		info.Version = e.serverVersion
+	}
+	return info
+}
+
+// 依次请求 /status 和 /stat，返回第一个能解析的状态页
+func fetchUdpxyStatus(t *Target, cfg *config.Config) *output.UdpxyInfo {
+	for _, statusPath := range udpxyStatusPaths {
+		st := t.WithPath(statusPath)
+		p, err := Fetch(st, cfg)
+		if err != nil {
+			log.Printf("请求 udpxy 状态页 %s 失败: %v\n", st.URL(), err)
+			return nil
+		}
+		if p.Resp.StatusCode != http.StatusOK {
+			p.Close()
+			continue
+		}
+		body, err := p.ReadText()
+		p.Close()
+		if err != nil {
+			log.Printf("读取 udpxy 状态页 %s 失败: %v\n", st.URL(), err)
+			return nil
+		}
+		if info := ParseUdpxyStatus(string(body)); info != nil {
+			log.Printf("udpxy %s:%d 版本: %s, 当前客户端: %d\n", t.IP, t.Port, info.Version, info.ActiveClients)
+			return info
+		}
+	}
+	return nil
+}
+
+// 解析 udpxy 状态页
+// 服务器信息表的表头包含 Accepting clients、Active clients（部分版本还有 Max clients），
+// 客户端表每行一个客户端，Throughput 列为该客户端的速率
+func ParseUdpxyStatus(page string) *output.UdpxyInfo {
+	info := &output.UdpxyInfo{ActiveClients: -1}
+	found := false
+	clientRows := 0
+
+	var headers []string
+	for _, row := range trRe.FindAllStringSubmatch(page, -1) {
+		cells := cellRe.FindAllStringSubmatch(row[1], -1)
+		if len(cells) == 0 {
+			continue
+		}
+		if strings.EqualFold(cells[0][1], "th") {
+			headers = headers[:0]
+			for _, c := range cells {
+				headers = append(headers, strings.ToLower(cellText(c[2])))
+			}
+			continue
+		}
+		isClientRow := false
+		for i, c := range cells {
+			if i >= len(headers) {
+				break
+			}
+			value := cellText(c[2])
+			switch {
+			case strings.Contains(headers[i], "active clients"):
+				if n, ok := firstNumber(value); ok {
+					info.ActiveClients = n
+					found = true
+				}
+			case strings.Contains(headers[i], "max"):
+				if n, ok := firstNumber(value); ok {
+					info.MaxClients = n
+					found = true
+				}
+			case strings.Contains(headers[i], "accepting clients"):
+				info.Accepting = strings.EqualFold(value, "yes")
+				found = true
+			case strings.Contains(headers[i], "throughput"):
+				isClientRow = true
+				info.ThroughputMbps += parseThroughput(value)
+			}
+		}
+		if isClientRow {
+			clientRows++
+		}
+	}
+
+	if m := pageVerRe.FindStringSubmatch(cellText(page)); m != nil {
+		info.Version = m[1]
+		found = true
+	}
+	if clientRows > 0 {
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	if info.ActiveClients < 0 {
+		// 没有 Active clients 列时按客户端表的行数计算
+		info.ActiveClients = clientRows
+	}
+	info.ThroughputMbps = math.Round(info.ThroughputMbps*100) / 100
+	return info
+}
+
+// 去掉标签和多余空白
+func cellText(s string) string {
+	s = html.UnescapeString(tagRe.ReplaceAllString(s, " "))
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func firstNumber(s string) (int, bool) {
+	m := numberRe.FindString(s)
+	if m == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m)
+	return n, err == nil
+}
+
+// 把 8.11Mb/sec、512Kb/sec 等速率换算为 Mbit/s
+func parseThroughput(s string) float64 {
+	m := throughRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0
+	}
+	switch strings.ToLower(m[2]) {
+	case "":
+		return v / 1000 / 1000
+	case "k":
+		return v / 1000
+	case "g":
+		return v * 1000
+	}
+	return v
+}
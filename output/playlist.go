@@ -0,0 +1,152 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 播放列表分组方式
+const (
+	GroupBySource = "source"
+	GroupByServer = "server"
+	GroupByKind   = "kind"
+)
+
+// 播放列表中无意义的文件名，遇到时改用上一级目录作为频道名
+var genericChannelNames = map[string]bool{
+	"index": true, "playlist": true, "live": true, "mnf": true, "main": true,
+	"chunklist": true, "master": true, "stream": true, "video": true, "hls": true,
+}
+
+var multicastPathRe = regexp.MustCompile(`(?:^|/)(rtp|udp)/([^/?]+)`)
+
+// playlistWriter 将可播放的命中结果写成 #EXTM3U 播放列表
+type playlistWriter struct {
+	mu      sync.Mutex
+	file    *os.File
+	groupBy string
+}
+
+// 打开播放列表文件，文件为空时写入 #EXTM3U 头
+func newPlaylistWriter(filename, groupBy string) (*playlistWriter, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		if _, err := file.WriteString("#EXTM3U\n"); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &playlistWriter{file: file, groupBy: strings.ToLower(groupBy)}, nil
+}
+
+func (w *playlistWriter) Write(r *Result) error {
+	switch r.Kind {
+	case KindUdpxy, KindFLV, KindVideo, KindM3U8:
+	default:
+		return nil // 播放页、接口等结果无法直接播放
+	}
+
+	name := channelName(r.Path)
+	group := w.group(r)
+	entry := fmt.Sprintf("#EXTINF:-1 tvg-name=\"%s\" group-title=\"%s\",%s\n%s\n",
+		escapeAttr(name), escapeAttr(group), escapeTitle(name), escapeURL(r.URL))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.file.WriteString(entry)
+	return err
+}
+
+func (w *playlistWriter) Close() error {
+	return w.file.Close()
+}
+
+// 计算分组名称
+func (w *playlistWriter) group(r *Result) string {
+	var group string
+	switch w.groupBy {
+	case GroupByServer:
+		group = r.Server
+	case GroupByKind:
+		group = r.Kind
+	default:
+		group = r.Source
+	}
+	if group == "" {
+		group = "未分组"
+	}
+	return group
+}
+
+// 根据探测路径生成频道名
+// udpxy 路径使用组播地址，其他路径使用文件名，文件名无意义时使用上一级目录
+func channelName(urlPath string) string {
+	urlPath = strings.SplitN(urlPath, "?", 2)[0]
+	if m := multicastPathRe.FindStringSubmatch(urlPath); m != nil {
+		return m[1] + "/" + m[2]
+	}
+
+	var segments []string
+	for _, seg := range strings.Split(urlPath, "/") {
+		if seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		name := strings.TrimSuffix(segments[i], path.Ext(segments[i]))
+		if name == "" || genericChannelNames[strings.ToLower(name)] || isShortNumber(name) {
+			continue
+		}
+		return name
+	}
+	if len(segments) > 0 {
+		return segments[len(segments)-1]
+	}
+	return urlPath
+}
+
+// 判断是否为 1、01 这类无意义的短数字
+func isShortNumber(s string) bool {
+	if len(s) > 2 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// 去掉属性值中会破坏 #EXTINF 格式的字符
+func escapeAttr(s string) string {
+	s = strings.ReplaceAll(s, "\"", "'")
+	return escapeTitle(s)
+}
+
+// 去掉频道名中的逗号和换行，{timestampMinus5} 展开的查询参数、虚拟主机路径等可能带有这些字符
+func escapeTitle(s string) string {
+	return strings.ReplaceAll(stripNewlines(s), ",", " ")
+}
+
+// 换行会把一条记录拆成多行，替换为空格
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// 地址中的换行按 URL 编码写入，保持地址可用
+func escapeURL(s string) string {
+	return strings.NewReplacer("\r", "%0D", "\n", "%0A").Replace(s)
+}
@@ -0,0 +1,152 @@
+package output
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 命中结果的检测类型
+const (
+	KindUdpxy      = "udpxy"
+	KindFLV        = "flv"
+	KindVideo      = "video"
+	KindM3U8       = "m3u8"
+	KindHTMLPlayer = "html-player"
+	KindJSON       = "json"
+	KindPage       = "page" // 检测规则 accept 且未指定 kind 的结果
+)
+
+// Result 一条扫描命中结果
+type Result struct {
+	IP          string           `json:"ip"`
+	Port        int              `json:"port"`
+	Path        string           `json:"path"`
+	URL         string           `json:"url"`
+	Scheme      string           `json:"scheme"`
+	Vhost       string           `json:"vhost,omitempty"`       // 探测时作为 Host 头发送的虚拟主机
+	Domain      string           `json:"domain,omitempty"`      // 域名展开时 IP 所属的域名
+	SourceAddr  string           `json:"source_addr,omitempty"` // 探测成功时使用的本地出口地址
+	Source      string           `json:"source,omitempty"`      // 来源，CIDR 文件中的原始行
+	Kind        string           `json:"kind"`
+	Label       string           `json:"label,omitempty"` // 命中的检测规则的标签
+	Server      string           `json:"server"`
+	ContentType string           `json:"content_type"`
+	StatusCode  int              `json:"status_code"`
+	Latency     time.Duration    `json:"-"`
+	Speed       *float64         `json:"speed_mbps,omitempty"` // 仅下载流媒体时有值，单位 MB/s
+	BytesRead   int64            `json:"bytes_read"`
+	Timestamp   time.Time        `json:"timestamp"`
+	Udpxy       *UdpxyInfo       `json:"udpxy,omitempty"`       // udpxy 命中时状态页中的信息
+	Stream      *StreamInfo      `json:"stream,omitempty"`      // 下载的流媒体数据解析结果
+	HLS         *HLSInfo         `json:"hls,omitempty"`         // m3u8 命中时播放列表的解析结果
+	Fingerprint *FingerprintInfo `json:"fingerprint,omitempty"` // 识别出的服务软件
+}
+
+// FingerprintInfo 按指纹库识别出的服务软件
+type FingerprintInfo struct {
+	Product     string `json:"product,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Title       string `json:"title,omitempty"`        // 网页标题
+	FaviconHash *int32 `json:"favicon_hash,omitempty"` // favicon.ico 的 mmh3 哈希，与 Shodan 的 http.favicon.hash 相同
+}
+
+// 返回产品和版本号，没有识别出产品时返回空字符串
+func (f *FingerprintInfo) Name() string {
+	if f == nil || f.Product == "" {
+		return ""
+	}
+	if f.Version == "" {
+		return f.Product
+	}
+	return f.Product + "/" + f.Version
+}
+
+// m3u8 媒体播放列表的直播状态
+const (
+	HLSLive   = "live"   // 再次请求时分片列表已更新
+	HLSVOD    = "vod"    // 带有 EXT-X-ENDLIST 的点播列表
+	HLSFrozen = "frozen" // 没有 EXT-X-ENDLIST，但再次请求时分片列表没有变化
+)
+
+// m3u8 分片的加密状态
+const (
+	HLSClear          = "clear"           // 分片不加密
+	HLSKeyReachable   = "key-reachable"   // 分片加密，EXT-X-KEY 的密钥可以获取
+	HLSKeyUnreachable = "key-unreachable" // 分片加密，密钥无法获取
+)
+
+// HLSInfo m3u8 播放列表的解析结果
+type HLSInfo struct {
+	Master         bool         `json:"master"`                    // 是否为主播放列表
+	Liveness       string       `json:"liveness,omitempty"`        // 开启 hlsLiveness 时的直播状态
+	Version        int          `json:"version,omitempty"`         // EXT-X-VERSION
+	TargetDuration float64      `json:"target_duration,omitempty"` // 媒体播放列表的 EXT-X-TARGETDURATION
+	Segments       int          `json:"segments,omitempty"`        // 媒体播放列表中的分片数
+	Container      string       `json:"container,omitempty"`       // 分片封装格式，ts 或 fmp4
+	Downloaded     int          `json:"downloaded,omitempty"`      // 下载成功的分片数
+	Encryption     string       `json:"encryption,omitempty"`      // 加密状态，主播放列表取各码率中最好的一个
+	KeyMethod      string       `json:"key_method,omitempty"`      // EXT-X-KEY 的 METHOD
+	Variants       []HLSVariant `json:"variants,omitempty"`        // 主播放列表中的各码率
+}
+
+// HLSVariant 主播放列表中一个码率的检查结果
+type HLSVariant struct {
+	URL              string  `json:"url"`
+	Bandwidth        int     `json:"bandwidth,omitempty"`
+	AverageBandwidth int     `json:"average_bandwidth,omitempty"`
+	Resolution       string  `json:"resolution,omitempty"`
+	FrameRate        float64 `json:"framerate,omitempty"`
+	Codecs           string  `json:"codecs,omitempty"`
+	Segments         int     `json:"segments,omitempty"`
+	Container        string  `json:"container,omitempty"`
+	Downloaded       int     `json:"downloaded,omitempty"`
+	Encryption       string  `json:"encryption,omitempty"`
+	KeyMethod        string  `json:"key_method,omitempty"`
+	OK               bool    `json:"ok"`
+	Error            string  `json:"error,omitempty"` // 检查失败的原因
+}
+
+// StreamInfo 流媒体数据的解析结果
+type StreamInfo struct {
+	Format      string   `json:"format"` // mpegts、flv
+	VideoCodec  string   `json:"video_codec,omitempty"`
+	AudioCodecs []string `json:"audio_codecs,omitempty"`
+
+	// FLV onMetaData 中的信息和标签统计
+	Width        int     `json:"width,omitempty"`
+	Height       int     `json:"height,omitempty"`
+	FrameRate    float64 `json:"framerate,omitempty"`
+	VideoBitrate float64 `json:"video_bitrate_kbps,omitempty"`
+	AudioBitrate float64 `json:"audio_bitrate_kbps,omitempty"`
+	VideoTags    int     `json:"video_tags,omitempty"`
+	AudioTags    int     `json:"audio_tags,omitempty"`
+
+	// MPEG-TS 解析统计
+	Packets    int     `json:"packets,omitempty"`     // TS 包数
+	SyncErrors int     `json:"sync_errors,omitempty"` // 同步字节丢失次数
+	CCErrors   int     `json:"cc_errors,omitempty"`   // 连续计数器错误
+	PCRErrors  int     `json:"pcr_errors,omitempty"`  // PCR 倒退或间隔超过 100ms
+	TEIErrors  int     `json:"tei_errors,omitempty"`  // 带传输错误标志的包
+	ErrorRate  float64 `json:"error_rate"`            // 各类错误之和占包数的比例
+}
+
+// UdpxyInfo udpxy 状态页中的服务器信息
+type UdpxyInfo struct {
+	Version        string  `json:"version,omitempty"`
+	Accepting      bool    `json:"accepting"`             // 是否还在接受新客户端
+	ActiveClients  int     `json:"active_clients"`        // 当前客户端数
+	MaxClients     int     `json:"max_clients,omitempty"` // 最大客户端数，状态页未显示时为 0
+	ThroughputMbps float64 `json:"throughput_mbps"`       // 所有客户端速率之和，单位 Mbit/s
+}
+
+// 输出 JSON 时耗时统一使用毫秒
+func (r *Result) MarshalJSON() ([]byte, error) {
+	type alias Result
+	return json.Marshal(struct {
+		*alias
+		LatencyMs float64 `json:"latency_ms"`
+	}{
+		alias:     (*alias)(r),
+		LatencyMs: float64(r.Latency) / float64(time.Millisecond),
+	})
+}
@@ -1,9 +1,128 @@
 package output
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
+
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/util"
+)
+
+// 结果输出格式
+const (
+	FormatText  = "text"
+	FormatJSONL = "jsonl"
 )
 
+// ResultWriter 扫描结果写入器
+type ResultWriter interface {
+	Write(r *Result) error
+	Close() error
+}
+
+// 根据配置创建结果写入器，默认使用文本格式
+// 配置了 playlistFile 时同时输出 M3U 播放列表
+func NewResultWriter(cfg *config.Config) (ResultWriter, error) {
+	w, err := newFileWriter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PlaylistFile == "" {
+		return w, nil
+	}
+	pw, err := newPlaylistWriter(cfg.PlaylistFile, cfg.PlaylistGroupBy)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return multiWriter{w, pw}, nil
+}
+
+// 创建 successfulIPsFile 的写入器
+func newFileWriter(cfg *config.Config) (ResultWriter, error) {
+	file, err := os.OpenFile(cfg.SuccessfulIPsFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(cfg.OutputFormat) {
+	case "", FormatText:
+		return &textWriter{file: file, cfg: cfg}, nil
+	case FormatJSONL:
+		return &jsonlWriter{file: file, enc: json.NewEncoder(file)}, nil
+	default:
+		file.Close()
+		return nil, fmt.Errorf("不支持的输出格式: %s", cfg.OutputFormat)
+	}
+}
+
+// textWriter 原有的文本格式，由 outputs 决定输出完整 url 还是 ip:端口
+type textWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	cfg  *config.Config
+}
+
+func (w *textWriter) Write(r *Result) error {
+	outputString := util.GenerateOutputString(r.IP, r.Port, r.URL, r.Vhost, r.Fingerprint.Name(), r.SourceAddr, r.Server, w.cfg, r.Latency, r.Speed)
+	// 去除输出字符串的首尾空白字符
+	trimmedOutput := strings.TrimSpace(outputString)
+	// 在写入文件之前检查去除空白后的字符串是否为空
+	if trimmedOutput == "" {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.file.WriteString(trimmedOutput + "\n")
+	return err
+}
+
+func (w *textWriter) Close() error {
+	return w.file.Close()
+}
+
+// jsonlWriter 每行一条 JSON 记录
+type jsonlWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func (w *jsonlWriter) Write(r *Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(r)
+}
+
+func (w *jsonlWriter) Close() error {
+	return w.file.Close()
+}
+
+// multiWriter 将结果依次写入多个写入器
+type multiWriter []ResultWriter
+
+func (m multiWriter) Write(r *Result) error {
+	var firstErr error
+	for _, w := range m {
+		if err := w.Write(r); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m multiWriter) Close() error {
+	var firstErr error
+	for _, w := range m {
+		if err := w.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 // appendToFile 将文本追加到文件中
 func AppendToFile(filename, text string) error {
 	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
@@ -26,4 +145,4 @@ func ClearFileContent(filename string) error {
 
 	// 创建一个新的文件（实际上是清空原有内容）
 	return nil
-}
\ No newline at end of file
+}
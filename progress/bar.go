@@ -0,0 +1,172 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const barWidth = 30
+
+// Bar 在终端显示单行进度条，标准输出不是终端时改为定期输出普通文本行
+// 所有方法都允许在 nil 上调用
+type Bar struct {
+	mu       sync.Mutex
+	counter  *Counter
+	out      io.Writer
+	tty      bool
+	interval time.Duration
+	stop     chan struct{}
+	wg       sync.WaitGroup
+
+	lastDone int64
+	lastTime time.Time
+	rate     float64 // 平滑后的每秒请求数
+	drawn    bool    // 当前行是否有进度条
+}
+
+// 创建进度条，lineInterval 为非终端时输出普通文本行的间隔
+func NewBar(counter *Counter, lineInterval time.Duration) *Bar {
+	b := &Bar{
+		counter:  counter,
+		out:      os.Stdout,
+		tty:      isTerminal(os.Stdout),
+		interval: lineInterval,
+		stop:     make(chan struct{}),
+		lastTime: time.Now(),
+	}
+	if b.tty {
+		b.interval = 500 * time.Millisecond
+	}
+	return b
+}
+
+// 启动刷新
+func (b *Bar) Start() {
+	if b == nil {
+		return
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.render(false)
+			case <-b.stop:
+				b.render(true)
+				return
+			}
+		}
+	}()
+}
+
+// 停止刷新并输出最终进度
+func (b *Bar) Stop() {
+	if b == nil {
+		return
+	}
+	close(b.stop)
+	b.wg.Wait()
+}
+
+// 在进度条上方输出内容，避免与进度条混在同一行
+func (b *Bar) Wrap(fn func()) {
+	if b == nil || !b.tty {
+		fn()
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.drawn {
+		fmt.Fprint(b.out, "\r\033[K")
+		b.drawn = false
+	}
+	fn()
+}
+
+// 刷新一次进度
+func (b *Bar) render(final bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.counter.Snapshot()
+	now := time.Now()
+	if elapsed := now.Sub(b.lastTime).Seconds(); elapsed > 0 {
+		instant := float64(s.Done-b.lastDone) / elapsed
+		if b.rate == 0 {
+			b.rate = instant
+		} else {
+			b.rate = b.rate*0.7 + instant*0.3
+		}
+	}
+	b.lastDone = s.Done
+	b.lastTime = now
+
+	line := b.format(s)
+	if b.tty {
+		fmt.Fprintf(b.out, "\r\033[K%s", line)
+		b.drawn = true
+		if final {
+			fmt.Fprintln(b.out)
+			b.drawn = false
+		}
+		return
+	}
+	fmt.Fprintln(b.out, line)
+}
+
+// 生成进度文本，总数未知时只显示已完成数、速率和成功数
+func (b *Bar) format(s Snapshot) string {
+	var percent float64
+	var text string
+	if s.Unknown {
+		text = fmt.Sprintf("%d/未知 %.0f req/s 成功: %d", s.Done, b.rate, s.Hits)
+	} else {
+		if s.Total > 0 {
+			percent = float64(s.Done) / float64(s.Total)
+			if percent > 1 {
+				percent = 1
+			}
+		}
+
+		eta := "未知"
+		if b.rate > 0 && s.Total > 0 {
+			seconds := float64(s.Remaining) / b.rate
+			if seconds < float64(math.MaxInt64/int64(time.Second)) {
+				eta = (time.Duration(seconds) * time.Second).String()
+			}
+		}
+
+		text = fmt.Sprintf("%.1f%% %d/%d %.0f req/s 成功: %d 剩余时间: %s",
+			percent*100, s.Done, s.Total, b.rate, s.Hits, eta)
+	}
+	if !b.tty {
+		return fmt.Sprintf("%s 进度: %s", time.Now().Format("2006-01-02 15:04:05"), text)
+	}
+	if s.Unknown {
+		return text
+	}
+
+	filled := int(percent * barWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+	return fmt.Sprintf("[%s] %s", bar, text)
+}
+
+// 判断输出是否为终端
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,82 @@
+package progress
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Logger 按固定间隔把扫描进度写入文件
+type Logger struct {
+	counter  *Counter
+	filename string
+	interval time.Duration
+	logIP    bool
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// 创建定时进度日志，logIP 为 true 时同时记录当前处理的 IP
+func NewLogger(counter *Counter, filename string, interval time.Duration, logIP bool) *Logger {
+	return &Logger{
+		counter:  counter,
+		filename: filename,
+		interval: interval,
+		logIP:    logIP,
+		stop:     make(chan struct{}),
+	}
+}
+
+// 启动定时写入
+func (l *Logger) Start() {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		ticker := time.NewTicker(l.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.write(false)
+			case <-l.stop:
+				l.write(true)
+				return
+			}
+		}
+	}()
+}
+
+// 停止定时写入，并写入最后一条进度
+func (l *Logger) Stop() {
+	close(l.stop)
+	l.wg.Wait()
+}
+
+// 写入一条进度记录，final 为 true 时标记扫描结束
+func (l *Logger) write(final bool) {
+	s := l.counter.Snapshot()
+	remaining := strconv.FormatInt(s.Remaining, 10)
+	if s.Unknown {
+		remaining = "未知"
+	}
+	line := fmt.Sprintf("%s 已用时: %v, 已完成: %d, 剩余: %s, 成功: %d",
+		time.Now().Format("2006-01-02 15:04:05"), s.Elapsed.Round(time.Second), s.Done, remaining, s.Hits)
+	if l.logIP && s.CurrentIP != "" {
+		line = fmt.Sprintf("%s, 当前IP: %s", line, s.CurrentIP)
+	}
+	if final {
+		line = fmt.Sprintf("%s, 扫描结束", line)
+	}
+
+	file, err := os.OpenFile(l.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		fmt.Println("写入进度日志失败:", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		fmt.Println("写入进度日志失败:", err)
+	}
+}
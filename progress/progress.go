@@ -0,0 +1,114 @@
+package progress
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Counter 扫描进度计数器，所有方法都允许在 nil 上调用
+type Counter struct {
+	start   time.Time
+	total   atomic.Int64
+	done    atomic.Int64
+	hits    atomic.Int64
+	current atomic.Value // 当前处理的 IP
+}
+
+// Snapshot 某一时刻的扫描进度
+type Snapshot struct {
+	Elapsed   time.Duration
+	Total     int64
+	Done      int64
+	Remaining int64
+	Unknown   bool // 任务总数超出计数范围（如较大的 IPv6 前缀），不显示百分比和剩余时间
+	Hits      int64
+	CurrentIP string
+}
+
+// 创建进度计数器，total 为预计的任务总数
+func NewCounter(total int64) *Counter {
+	c := &Counter{start: time.Now()}
+	c.total.Store(total)
+	return c
+}
+
+// 一个任务执行完成
+func (c *Counter) Done() {
+	if c == nil {
+		return
+	}
+	c.done.Add(1)
+}
+
+// 增加 n 个预计任务（域名展开为多个 IP 时）
+func (c *Counter) AddTotal(n int64) {
+	if c == nil || n <= 0 {
+		return
+	}
+	addSaturated(&c.total, n)
+}
+
+// 跳过 n 个任务（断点恢复时已完成的任务）
+func (c *Counter) Skip(n int64) {
+	if c == nil || n <= 0 {
+		return
+	}
+	addSaturated(&c.done, n)
+}
+
+// 新增一条成功结果
+func (c *Counter) Hit() {
+	if c == nil {
+		return
+	}
+	c.hits.Add(1)
+}
+
+// 记录当前处理的 IP
+func (c *Counter) SetCurrent(ip string) {
+	if c == nil {
+		return
+	}
+	c.current.Store(ip)
+}
+
+// 获取当前进度
+func (c *Counter) Snapshot() Snapshot {
+	if c == nil {
+		return Snapshot{}
+	}
+	s := Snapshot{
+		Elapsed: time.Since(c.start),
+		Total:   c.total.Load(),
+		Done:    c.done.Load(),
+		Hits:    c.hits.Load(),
+	}
+	if ip, ok := c.current.Load().(string); ok {
+		s.CurrentIP = ip
+	}
+	if s.Total == math.MaxInt64 {
+		s.Unknown = true
+		s.Remaining = 0
+		return s
+	}
+	s.Remaining = s.Total - s.Done
+	if s.Remaining < 0 {
+		s.Remaining = 0
+	}
+	return s
+}
+
+// 溢出时取最大值的累加，达到最大值后不再变化
+func addSaturated(v *atomic.Int64, n int64) {
+	for {
+		old := v.Load()
+		next := old + n
+		if old > math.MaxInt64-n {
+			next = math.MaxInt64
+		}
+		if v.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 未配置时的默认值
+const (
+	defaultMaxFails = 3
+	defaultCooldown = 60 * time.Second
+)
+
+// DialFunc 建立 TCP 连接的函数，与 net.Dialer.DialContext 相同
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// LookupFunc 解析域名的函数，socks5:// 代理在本地解析目标域名时使用
+type LookupFunc func(ctx context.Context, host string) ([]net.IP, error)
+
+// errTarget 代理本身可用，只是目标地址无法连接，不计入代理失败次数
+var errTarget = errors.New("代理无法连接目标")
+
+// Proxy 一个上游代理及其健康状态
+type Proxy struct {
+	URL *url.URL
+
+	mu            sync.Mutex
+	failures      int       // 连续失败次数
+	cooldownUntil time.Time // 冷却结束时间，冷却期间不再选用
+}
+
+// Pool 代理列表，轮流选用健康的代理，所有方法都允许在 nil 上调用，nil 表示直连
+type Pool struct {
+	proxies  []*Proxy
+	next     uint64
+	maxFails int           // 连续失败多少次后进入冷却
+	cooldown time.Duration // 冷却时长
+	timeout  time.Duration // 与代理握手的超时
+	lookup   LookupFunc    // socks5:// 代理解析目标域名，默认使用系统解析
+}
+
+// 根据代理地址列表创建代理池，列表为空时返回 nil
+// 支持 socks5://[user:pass@]host:port、socks5h://[user:pass@]host:port 和 http://[user:pass@]host:port（HTTP CONNECT）
+// socks5:// 在本地解析目标域名后把 IP 发给代理，socks5h:// 和 http:// 把域名交给代理解析
+func New(urls []string, maxFails int, cooldown, timeout time.Duration) (*Pool, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	if maxFails <= 0 {
+		maxFails = defaultMaxFails
+	}
+	if cooldown <= 0 {
+		cooldown = defaultCooldown
+	}
+	pool := &Pool{maxFails: maxFails, cooldown: cooldown, timeout: timeout, lookup: systemLookup}
+	for _, raw := range urls {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("解析代理地址 %s 失败: %v", raw, err)
+		}
+		switch u.Scheme {
+		case "socks5", "socks5h", "http":
+		default:
+			return nil, fmt.Errorf("不支持的代理类型: %s", raw)
+		}
+		if u.Port() == "" {
+			return nil, fmt.Errorf("代理地址缺少端口: %s", raw)
+		}
+		pool.proxies = append(pool.proxies, &Proxy{URL: u})
+	}
+	return pool, nil
+}
+
+// 设置 socks5:// 代理在本地解析目标域名使用的解析函数
+func (p *Pool) SetLookup(lookup LookupFunc) {
+	if p == nil || lookup == nil {
+		return
+	}
+	p.lookup = lookup
+}
+
+func systemLookup(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// 轮流选择下一个不在冷却中的代理，全部冷却时选用最早结束冷却的代理
+func (p *Pool) Next() *Proxy {
+	now := time.Now()
+	n := len(p.proxies)
+	start := atomic.AddUint64(&p.next, 1)
+	var earliest *Proxy
+	var earliestUntil time.Time
+	for i := 0; i < n; i++ {
+		px := p.proxies[(start+uint64(i))%uint64(n)]
+		until := px.until()
+		if !now.Before(until) {
+			return px
+		}
+		if earliest == nil || until.Before(earliestUntil) {
+			earliest, earliestUntil = px, until
+		}
+	}
+	return earliest
+}
+
+// 返回经过代理建立连接的拨号函数，dial 用于连接代理服务器
+// 代理本身失败时换下一个代理重试，最多把列表中的代理各试一次
+// p 为 nil 时直接返回 dial
+func (p *Pool) DialContext(dial DialFunc) DialFunc {
+	if p == nil {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var err error
+		for i := 0; i < len(p.proxies); i++ {
+			px := p.Next()
+			var conn net.Conn
+			conn, err = p.dial(ctx, px, dial, addr)
+			if err == nil {
+				px.success()
+				return conn, nil
+			}
+			err = fmt.Errorf("通过代理 %s 连接 %s 失败: %w", px.URL.Redacted(), addr, err)
+			if errors.Is(err, errTarget) || ctx.Err() != nil {
+				// 目标不可达或请求已取消，与代理健康无关
+				return nil, err
+			}
+			px.failure(p.maxFails, p.cooldown)
+		}
+		return nil, err
+	}
+}
+
+func (p *Pool) dial(ctx context.Context, px *Proxy, dial DialFunc, addr string) (net.Conn, error) {
+	addr, err := p.targetAddr(ctx, px, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dial(ctx, "tcp", px.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	// 握手阶段使用单独的超时，握手完成后清除
+	var deadline time.Time
+	if p.timeout > 0 {
+		deadline = time.Now().Add(p.timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	if !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+	if px.URL.Scheme == "http" {
+		conn, err = connectHTTP(conn, px.URL, addr)
+	} else {
+		err = connectSOCKS5(conn, px.URL, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// 返回发给代理的目标地址：socks5:// 代理的目标为域名时在本地解析，使用第一个解析出的 IP
+func (p *Pool) targetAddr(ctx context.Context, px *Proxy, addr string) (string, error) {
+	if px.URL.Scheme != "socks5" {
+		return addr, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	ips, err := p.lookup(ctx, host)
+	if err == nil && len(ips) == 0 {
+		err = errors.New("没有解析到地址")
+	}
+	if err != nil {
+		// 解析失败与代理健康无关
+		return "", fmt.Errorf("%w: 解析 %s 失败: %v", errTarget, host, err)
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+func (px *Proxy) until() time.Time {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	return px.cooldownUntil
+}
+
+func (px *Proxy) success() {
+	px.mu.Lock()
+	px.failures = 0
+	px.mu.Unlock()
+}
+
+// 记录一次失败，连续失败达到 maxFails 次后进入冷却
+func (px *Proxy) failure(maxFails int, cooldown time.Duration) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	px.failures++
+	if px.failures >= maxFails {
+		px.failures = 0
+		px.cooldownUntil = time.Now().Add(cooldown)
+	}
+}
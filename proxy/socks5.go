@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+const socks5Version = 5
+
+// SOCKS5 认证方式
+const (
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+)
+
+// SOCKS5 地址类型
+const (
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+)
+
+// 通过 SOCKS5 代理连接目标地址（RFC 1928、RFC 1929），addr 为域名时交给代理解析
+func connectSOCKS5(conn net.Conn, proxyURL *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("无效的端口: %s", portStr)
+	}
+
+	// 协商认证方式
+	methods := []byte{socks5AuthNone}
+	if proxyURL.User != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("不是 SOCKS5 代理: 版本 %d", reply[0])
+	}
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if err := socks5Auth(conn, proxyURL.User); err != nil {
+			return err
+		}
+	case socks5AuthNoAccept:
+		return errors.New("SOCKS5 代理不接受任何认证方式")
+	default:
+		return fmt.Errorf("SOCKS5 代理选择了不支持的认证方式: %d", reply[1])
+	}
+
+	// 发送 CONNECT 请求
+	req := []byte{socks5Version, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("域名过长: %s", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// 读取响应: VER REP RSV ATYP BND.ADDR BND.PORT
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[0] != socks5Version {
+		return fmt.Errorf("不是 SOCKS5 代理: 版本 %d", head[0])
+	}
+	if head[1] != 0x00 {
+		err := fmt.Errorf("SOCKS5 代理返回错误: %s", socks5ReplyText(head[1]))
+		if head[1] >= 0x03 && head[1] <= 0x06 {
+			// 网络不可达、主机不可达、连接被拒绝、TTL 过期属于目标问题
+			return fmt.Errorf("%w: %v", errTarget, err)
+		}
+		return err
+	}
+	var skip int
+	switch head[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len
+	case socks5AddrIPv6:
+		skip = net.IPv6len
+	case socks5AddrDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		skip = int(l[0])
+	default:
+		return fmt.Errorf("SOCKS5 代理返回未知地址类型: %d", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// 用户名密码认证
+func socks5Auth(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("SOCKS5 用户名或密码过长")
+	}
+	req := []byte{0x01, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return errors.New("SOCKS5 代理认证失败")
+	}
+	return nil
+}
+
+func socks5ReplyText(code byte) string {
+	switch code {
+	case 0x01:
+		return "服务器故障"
+	case 0x02:
+		return "规则不允许连接"
+	case 0x03:
+		return "网络不可达"
+	case 0x04:
+		return "主机不可达"
+	case 0x05:
+		return "连接被拒绝"
+	case 0x06:
+		return "TTL 过期"
+	case 0x07:
+		return "不支持的命令"
+	case 0x08:
+		return "不支持的地址类型"
+	default:
+		return fmt.Sprintf("未知错误 %d", code)
+	}
+}
@@ -0,0 +1,150 @@
+package resolver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNS 记录类型
+const (
+	typeA     = 1
+	typeCNAME = 5
+	typeAAAA  = 28
+	classIN   = 1
+)
+
+// DNS 响应码
+const (
+	rcodeSuccess  = 0
+	rcodeNXDomain = 3
+)
+
+// errNotFound 域名不存在或没有对应类型的记录
+var errNotFound = errors.New("域名不存在")
+
+// 一次 DNS 查询的结果
+type answer struct {
+	ips []net.IP
+	ttl time.Duration // 所有记录中最小的 TTL
+}
+
+// 构造一个递归查询报文
+func buildQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, 12, 12+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // RD
+	binary.BigEndian.PutUint16(msg[4:], 1)      // QDCOUNT
+	name = strings.TrimSuffix(name, ".")
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("无效的域名: %s", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, classIN)
+	return msg, nil
+}
+
+// 解析响应报文，返回 A/AAAA 记录和是否被截断
+// 递归服务器会在回答中带上完整的 CNAME 链，这里直接收集所有 qtype 类型的记录
+func parseResponse(msg []byte, id uint16, qtype uint16) (*answer, bool, error) {
+	if len(msg) < 12 {
+		return nil, false, errors.New("DNS 响应过短")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, false, errors.New("DNS 响应 ID 不匹配")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&0x8000 == 0 {
+		return nil, false, errors.New("不是 DNS 响应")
+	}
+	truncated := flags&0x0200 != 0
+	switch rcode := flags & 0x000f; rcode {
+	case rcodeSuccess:
+	case rcodeNXDomain:
+		return nil, truncated, errNotFound
+	default:
+		return nil, truncated, fmt.Errorf("DNS 服务器返回错误码 %d", rcode)
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, truncated, err
+		}
+		off += 4
+	}
+
+	ans := &answer{}
+	first := true // TTL 为 0 的记录也是有效值，不能用 0 表示尚未设置
+	for i := 0; i < ancount; i++ {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, truncated, err
+		}
+		if off+10 > len(msg) {
+			return nil, truncated, errors.New("DNS 响应记录不完整")
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		class := binary.BigEndian.Uint16(msg[off+2:])
+		ttl := time.Duration(binary.BigEndian.Uint32(msg[off+4:])) * time.Second
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, truncated, errors.New("DNS 响应记录不完整")
+		}
+		rdata := msg[off : off+rdlen]
+		off += rdlen
+		if class != classIN || rtype != qtype {
+			continue
+		}
+		if (rtype == typeA && rdlen != net.IPv4len) || (rtype == typeAAAA && rdlen != net.IPv6len) {
+			continue
+		}
+		ip := make(net.IP, rdlen)
+		copy(ip, rdata)
+		ans.ips = append(ans.ips, ip)
+		if first || ttl < ans.ttl {
+			ans.ttl = ttl
+			first = false
+		}
+	}
+	if len(ans.ips) == 0 {
+		return nil, truncated, errNotFound
+	}
+	return ans, truncated, nil
+}
+
+// 跳过报文中的一个域名，支持压缩指针
+// 只需要跳过域名，不跟随指针，指针循环也不会导致死循环
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errors.New("DNS 响应域名不完整")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			return off + 1, nil
+		case l&0xc0 == 0xc0:
+			// 压缩指针占两个字节，指针之后域名结束
+			if off+2 > len(msg) {
+				return 0, errors.New("DNS 响应域名不完整")
+			}
+			return off + 2, nil
+		case l&0xc0 != 0:
+			return 0, fmt.Errorf("DNS 响应域名标签类型 %#x 无效", l&0xc0)
+		default:
+			off += 1 + l
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package resolver
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testID = 0x1234
+
+// 域名的标签编码
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(name, ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// 指向报文中 off 位置的压缩指针
+func pointer(off int) []byte {
+	return []byte{0xc0 | byte(off>>8), byte(off)}
+}
+
+// rr 一条资源记录，name 为已编码的域名
+type rr struct {
+	name  []byte
+	rtype uint16
+	ttl   uint32
+	rdata []byte
+}
+
+// 构造响应报文，问题为 example.com，位于偏移 12
+func buildResponse(flags uint16, qtype uint16, records ...rr) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, testID)
+	msg = binary.BigEndian.AppendUint16(msg, flags)
+	msg = binary.BigEndian.AppendUint16(msg, 1)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(records)))
+	msg = append(msg, 0, 0, 0, 0)
+	msg = append(msg, encodeName("example.com")...)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, classIN)
+	for _, r := range records {
+		msg = append(msg, r.name...)
+		msg = binary.BigEndian.AppendUint16(msg, r.rtype)
+		msg = binary.BigEndian.AppendUint16(msg, classIN)
+		msg = binary.BigEndian.AppendUint32(msg, r.ttl)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(r.rdata)))
+		msg = append(msg, r.rdata...)
+	}
+	return msg
+}
+
+const respOK = 0x8180 // QR、RD、RA
+
+func aRecord(ip string, ttl uint32) rr {
+	return rr{name: pointer(12), rtype: typeA, ttl: ttl, rdata: net.ParseIP(ip).To4()}
+}
+
+func TestParseResponse(t *testing.T) {
+	// 问题在偏移 12，长 17 字节，第一条记录从 29 开始
+	// A 记录的名称用指针指向 CNAME 的 rdata（edge 加指向问题的指针）
+	const cnameTarget = 29 + 2 + 10
+	cnameMsg := buildResponse(respOK, typeA,
+		rr{name: pointer(12), rtype: typeCNAME, ttl: 600, rdata: slices.Concat([]byte{4, 'e', 'd', 'g', 'e'}, pointer(12))},
+		rr{name: pointer(cnameTarget), rtype: typeA, ttl: 120, rdata: net.ParseIP("192.0.2.7").To4()},
+	)
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		qtype   uint16
+		ips     []string
+		ttl     time.Duration
+		trunc   bool
+		wantErr error // 为 nil 且 ips 为空时只检查有错误
+	}{
+		{
+			name:  "min ttl",
+			msg:   buildResponse(respOK, typeA, aRecord("192.0.2.1", 300), aRecord("192.0.2.2", 60)),
+			qtype: typeA,
+			ips:   []string{"192.0.2.1", "192.0.2.2"},
+			ttl:   60 * time.Second,
+		},
+		{
+			name:  "zero ttl first",
+			msg:   buildResponse(respOK, typeA, aRecord("192.0.2.1", 0), aRecord("192.0.2.2", 300)),
+			qtype: typeA,
+			ips:   []string{"192.0.2.1", "192.0.2.2"},
+			ttl:   0,
+		},
+		{
+			name:  "zero ttl last",
+			msg:   buildResponse(respOK, typeA, aRecord("192.0.2.1", 300), aRecord("192.0.2.2", 0)),
+			qtype: typeA,
+			ips:   []string{"192.0.2.1", "192.0.2.2"},
+			ttl:   0,
+		},
+		{
+			name:  "cname chain",
+			msg:   cnameMsg,
+			qtype: typeA,
+			ips:   []string{"192.0.2.7"},
+			ttl:   120 * time.Second,
+		},
+		{
+			name: "uncompressed and partially compressed names",
+			msg: buildResponse(respOK, typeA,
+				rr{name: encodeName("example.com"), rtype: typeA, ttl: 30, rdata: net.ParseIP("192.0.2.1").To4()},
+				rr{name: slices.Concat([]byte{3, 'w', 'w', 'w'}, pointer(12)), rtype: typeA, ttl: 30, rdata: net.ParseIP("192.0.2.2").To4()},
+			),
+			qtype: typeA,
+			ips:   []string{"192.0.2.1", "192.0.2.2"},
+			ttl:   30 * time.Second,
+		},
+		{
+			name: "pointer loop",
+			// 第一条记录的名称指针指向自身，只跳过域名不跟随指针，不会死循环
+			msg: buildResponse(respOK, typeA,
+				rr{name: pointer(29), rtype: typeA, ttl: 30, rdata: net.ParseIP("192.0.2.1").To4()},
+			),
+			qtype: typeA,
+			ips:   []string{"192.0.2.1"},
+			ttl:   30 * time.Second,
+		},
+		{
+			name: "aaaa",
+			msg: buildResponse(respOK, typeAAAA,
+				rr{name: pointer(12), rtype: typeAAAA, ttl: 90, rdata: net.ParseIP("2001:db8::1")},
+				aRecord("192.0.2.1", 10), // 类型不符的记录忽略
+			),
+			qtype: typeAAAA,
+			ips:   []string{"2001:db8::1"},
+			ttl:   90 * time.Second,
+		},
+		{
+			name: "bad rdata length ignored",
+			msg: buildResponse(respOK, typeA,
+				rr{name: pointer(12), rtype: typeA, ttl: 10, rdata: []byte{1, 2, 3}},
+				aRecord("192.0.2.1", 20),
+			),
+			qtype: typeA,
+			ips:   []string{"192.0.2.1"},
+			ttl:   20 * time.Second,
+		},
+		{
+			name:  "truncated flag",
+			msg:   buildResponse(respOK|0x0200, typeA, aRecord("192.0.2.1", 20)),
+			qtype: typeA,
+			ips:   []string{"192.0.2.1"},
+			ttl:   20 * time.Second,
+			trunc: true,
+		},
+		{
+			name:    "nxdomain",
+			msg:     buildResponse(respOK|rcodeNXDomain, typeA),
+			qtype:   typeA,
+			wantErr: errNotFound,
+		},
+		{
+			name:    "no records of type",
+			msg:     buildResponse(respOK, typeAAAA, aRecord("192.0.2.1", 20)),
+			qtype:   typeAAAA,
+			wantErr: errNotFound,
+		},
+		{
+			name:  "server failure",
+			msg:   buildResponse(respOK|2, typeA),
+			qtype: typeA,
+		},
+		{
+			name:  "not a response",
+			msg:   buildResponse(0x0100, typeA, aRecord("192.0.2.1", 20)),
+			qtype: typeA,
+		},
+		{
+			name:  "reserved label type",
+			msg:   buildResponse(respOK, typeA, rr{name: []byte{0x40, 1}, rtype: typeA, ttl: 1, rdata: net.ParseIP("192.0.2.1").To4()}),
+			qtype: typeA,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ans, trunc, err := parseResponse(tt.msg, testID, tt.qtype)
+			if trunc != tt.trunc {
+				t.Errorf("truncated = %v, want %v", trunc, tt.trunc)
+			}
+			if len(tt.ips) == 0 {
+				if err == nil {
+					t.Fatalf("parseResponse() = %v, want error", ans.ips)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseResponse() error = %v", err)
+			}
+			var ips []string
+			for _, ip := range ans.ips {
+				ips = append(ips, ip.String())
+			}
+			if !slices.Equal(ips, tt.ips) {
+				t.Errorf("ips = %v, want %v", ips, tt.ips)
+			}
+			if ans.ttl != tt.ttl {
+				t.Errorf("ttl = %v, want %v", ans.ttl, tt.ttl)
+			}
+		})
+	}
+}
+
+func TestParseResponseTruncatedPacket(t *testing.T) {
+	msg := buildResponse(respOK, typeA,
+		rr{name: slices.Concat([]byte{3, 'w', 'w', 'w'}, pointer(12)), rtype: typeA, ttl: 30, rdata: net.ParseIP("192.0.2.1").To4()},
+		aRecord("192.0.2.2", 30),
+	)
+	if _, _, err := parseResponse(msg, testID, typeA); err != nil {
+		t.Fatalf("full message: %v", err)
+	}
+	// 任意位置截断都应返回错误而不是 panic
+	for n := range len(msg) {
+		if ans, _, err := parseResponse(msg[:n], testID, typeA); err == nil {
+			t.Errorf("truncated to %d bytes: got %v, want error", n, ans.ips)
+		}
+	}
+}
+
+func TestParseResponseID(t *testing.T) {
+	msg := buildResponse(respOK, typeA, aRecord("192.0.2.1", 20))
+	if _, _, err := parseResponse(msg, testID+1, typeA); err == nil {
+		t.Error("mismatched ID accepted")
+	}
+}
+
+func TestSkipName(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     []byte
+		off     int
+		want    int
+		wantErr bool
+	}{
+		{name: "labels", msg: encodeName("a.example.com"), want: 15},
+		{name: "root", msg: []byte{0}, want: 1},
+		{name: "pointer", msg: pointer(0), want: 2},
+		{name: "labels then pointer", msg: slices.Concat([]byte{1, 'a'}, pointer(0)), want: 4},
+		{name: "self pointer", msg: []byte{0, 0, 0xc0, 2}, off: 2, want: 4},
+		{name: "half pointer", msg: []byte{0xc0}, wantErr: true},
+		{name: "label past end", msg: []byte{5, 'a', 'b'}, wantErr: true},
+		{name: "missing terminator", msg: []byte{1, 'a'}, wantErr: true},
+		{name: "reserved label type", msg: []byte{0x80, 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := skipName(tt.msg, tt.off)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("skipName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("skipName() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildQuery(t *testing.T) {
+	msg, err := buildQuery(testID, "www.example.com.", typeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Concat(
+		[]byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0},
+		encodeName("www.example.com"),
+		[]byte{0, typeAAAA, 0, classIN},
+	)
+	if !slices.Equal(msg, want) {
+		t.Errorf("buildQuery() = %x, want %x", msg, want)
+	}
+	for _, name := range []string{"a..com", strings.Repeat("x", 64) + ".com", ""} {
+		if _, err := buildQuery(testID, name, typeA); err == nil {
+			t.Errorf("buildQuery(%q) accepted", name)
+		}
+	}
+}
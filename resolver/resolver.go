@@ -0,0 +1,294 @@
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 未配置时的默认值
+const (
+	defaultTimeout   = 2 * time.Second
+	defaultSystemTTL = 60 * time.Second
+	negativeTTL      = 30 * time.Second // 域名不存在时的缓存时间
+	maxTTL           = time.Hour        // 缓存时间上限
+	sweepInterval    = time.Minute      // 清理过期缓存的最小间隔
+)
+
+// Resolver 带缓存的域名解析器，所有方法都允许在 nil 上调用，nil 表示使用系统解析且不缓存
+type Resolver struct {
+	servers   []string      // DNS 服务器 host:port，为空时使用系统解析
+	timeout   time.Duration // 单次查询超时
+	systemTTL time.Duration // 系统解析拿不到 TTL，使用固定缓存时间
+
+	mu        sync.Mutex
+	cache     map[string]*entry
+	nextSweep time.Time // 下次清理过期缓存的时间
+}
+
+// 一个域名的缓存，done 关闭前表示正在解析，同一域名的并发请求等待同一次解析
+type entry struct {
+	done    chan struct{}
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+var defaultResolver *Resolver
+
+// 设置 LookupIP 使用的默认解析器
+func SetDefault(r *Resolver) {
+	defaultResolver = r
+}
+
+// 使用默认解析器解析域名
+func LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return defaultResolver.LookupIP(ctx, host)
+}
+
+// 创建解析器，servers 为 DNS 服务器地址（可省略端口 53），为空时使用系统解析
+// systemTTL 为系统解析结果的缓存时间，自定义 DNS 服务器时按记录的 TTL 缓存
+func New(servers []string, timeout, systemTTL time.Duration) (*Resolver, error) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if systemTTL <= 0 {
+		systemTTL = defaultSystemTTL
+	}
+	r := &Resolver{
+		timeout:   timeout,
+		systemTTL: systemTTL,
+		cache:     make(map[string]*entry),
+	}
+	for _, server := range servers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		host, _, _ := net.SplitHostPort(server)
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("DNS 服务器必须是 IP 地址: %s", server)
+		}
+		r.servers = append(r.servers, server)
+	}
+	return r, nil
+}
+
+// 解析域名的 A 和 AAAA 记录，IPv4 地址在前，IP 字面量直接返回
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if r == nil {
+		return lookupSystem(ctx, host, defaultTimeout)
+	}
+
+	r.mu.Lock()
+	r.sweepLocked(time.Now())
+	e, ok := r.cache[host]
+	if ok {
+		select {
+		case <-e.done:
+			if time.Now().Before(e.expires) {
+				r.mu.Unlock()
+				return e.ips, e.err
+			}
+			ok = false // 已过期，重新解析
+		default:
+		}
+	}
+	if !ok {
+		e = &entry{done: make(chan struct{})}
+		r.cache[host] = e
+		r.mu.Unlock()
+
+		ips, ttl, err := r.resolve(host)
+		e.ips, e.err = ips, err
+		if err != nil && !errors.Is(err, errNotFound) {
+			ttl = 0 // 超时等临时错误不缓存
+		}
+		e.expires = time.Now().Add(ttl)
+		close(e.done)
+		return ips, err
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return e.ips, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 删除已过期的缓存，展开大量域名时不保留已失效的条目，每 sweepInterval 最多执行一次
+// 正在解析的条目保留
+func (r *Resolver) sweepLocked(now time.Time) {
+	if now.Before(r.nextSweep) {
+		return
+	}
+	r.nextSweep = now.Add(sweepInterval)
+	for host, e := range r.cache {
+		select {
+		case <-e.done:
+			if !now.Before(e.expires) {
+				delete(r.cache, host)
+			}
+		default:
+		}
+	}
+}
+
+// 实际解析，返回 IP、缓存时间和错误
+// 解析结果被多个请求共用，不使用调用方的 ctx，只受 timeout 限制
+func (r *Resolver) resolve(host string) ([]net.IP, time.Duration, error) {
+	if len(r.servers) == 0 {
+		ips, err := lookupSystem(context.Background(), host, r.timeout)
+		if err != nil {
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				return nil, negativeTTL, fmt.Errorf("解析 %s 失败: %w", host, errNotFound)
+			}
+			return nil, 0, err
+		}
+		return ips, r.systemTTL, nil
+	}
+
+	// 同时查询 A 和 AAAA
+	var v4, v6 *answer
+	var err4, err6 error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		v4, err4 = r.exchange(host, typeA)
+	}()
+	go func() {
+		defer wg.Done()
+		v6, err6 = r.exchange(host, typeAAAA)
+	}()
+	wg.Wait()
+
+	var ips []net.IP
+	ttl := maxTTL
+	for _, ans := range []*answer{v4, v6} {
+		if ans == nil {
+			continue
+		}
+		ips = append(ips, ans.ips...)
+		if ans.ttl < ttl {
+			ttl = ans.ttl
+		}
+	}
+	if len(ips) > 0 {
+		return ips, ttl, nil
+	}
+	if errors.Is(err4, errNotFound) && errors.Is(err6, errNotFound) {
+		return nil, negativeTTL, fmt.Errorf("解析 %s 失败: %w", host, errNotFound)
+	}
+	if err4 == nil || errors.Is(err4, errNotFound) {
+		err4 = err6
+	}
+	return nil, 0, fmt.Errorf("解析 %s 失败: %w", host, err4)
+}
+
+// 依次向配置的 DNS 服务器查询，域名不存在时不再尝试其他服务器
+func (r *Resolver) exchange(host string, qtype uint16) (*answer, error) {
+	var lastErr error
+	for _, server := range r.servers {
+		ans, err := r.query(server, host, qtype)
+		if err == nil || errors.Is(err, errNotFound) {
+			return ans, err
+		}
+		lastErr = fmt.Errorf("DNS 服务器 %s: %v", server, err)
+	}
+	return nil, lastErr
+}
+
+// 先使用 UDP 查询，响应被截断时改用 TCP
+func (r *Resolver) query(server, host string, qtype uint16) (*answer, error) {
+	id := uint16(rand.Uint32())
+	msg, err := buildQuery(id, host, qtype)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.roundTrip("udp", server, msg)
+	if err != nil {
+		return nil, err
+	}
+	ans, truncated, err := parseResponse(resp, id, qtype)
+	if !truncated {
+		return ans, err
+	}
+	if resp, err = r.roundTrip("tcp", server, msg); err != nil {
+		return nil, err
+	}
+	ans, _, err = parseResponse(resp, id, qtype)
+	return ans, err
+}
+
+func (r *Resolver) roundTrip(network, server string, msg []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, r.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.timeout))
+
+	if network == "tcp" {
+		// TCP 报文前有两字节长度
+		if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(msg)))); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		var l [2]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return nil, err
+		}
+		resp := make([]byte, binary.BigEndian.Uint16(l[:]))
+		_, err := io.ReadFull(conn, resp)
+		return resp, err
+	}
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, 4096)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp[:n], nil
+}
+
+// 使用系统解析，IPv4 地址在前
+func lookupSystem(ctx context.Context, host string, timeout time.Duration) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var v4, v6 []net.IP
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			v4 = append(v4, addr.IP)
+		} else {
+			v6 = append(v6, addr.IP)
+		}
+	}
+	return append(v4, v6...), nil
+}
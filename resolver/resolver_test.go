@@ -0,0 +1,45 @@
+package resolver
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSweepExpired(t *testing.T) {
+	r, err := New(nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	closed := func(expires time.Time) *entry {
+		e := &entry{done: make(chan struct{}), expires: expires}
+		close(e.done)
+		return e
+	}
+	r.cache["live.example"] = closed(now.Add(time.Minute))
+	r.cache["expired.example"] = closed(now.Add(-time.Second))
+	r.cache["negative.example"] = closed(now)
+	r.cache["pending.example"] = &entry{done: make(chan struct{})}
+
+	r.sweepLocked(now)
+	var hosts []string
+	for host := range r.cache {
+		hosts = append(hosts, host)
+	}
+	slices.Sort(hosts)
+	if want := []string{"live.example", "pending.example"}; !slices.Equal(hosts, want) {
+		t.Errorf("cache = %v, want %v", hosts, want)
+	}
+
+	// 间隔内不再清理
+	r.cache["expired.example"] = closed(now.Add(-time.Second))
+	r.sweepLocked(now.Add(sweepInterval / 2))
+	if _, ok := r.cache["expired.example"]; !ok {
+		t.Error("swept again within sweepInterval")
+	}
+	r.sweepLocked(now.Add(sweepInterval))
+	if _, ok := r.cache["expired.example"]; ok {
+		t.Error("expired entry kept after sweepInterval")
+	}
+}
@@ -0,0 +1,264 @@
+package rules
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 规则动作
+const (
+	ActionAccept         = "accept"          // 直接作为命中结果
+	ActionReject         = "reject"          // 丢弃，不再检测
+	ActionDownloadStream = "download-stream" // 下载流媒体数据并验证
+	ActionDownloadTS     = "download-ts"     // 按 m3u8 播放列表检查，download_ts 为 true 时下载分片
+	ActionFollowLink     = "follow-link"     // 从响应体中提取地址，请求后重新匹配规则
+)
+
+// 未配置 link 时 follow-link 提取的地址：http(s) 绝对地址或 m3u8、flv 相对地址
+const defaultLinkPattern = `https?://[^\s"'<>\\]+|[^\s"'<>\\]+\.(?:m3u8|flv)(?:\?[^\s"'<>\\]*)?`
+
+//go:embed default.yaml
+var defaultRules []byte
+
+// Rule 一条内容检测规则
+type Rule struct {
+	Name   string `yaml:"name"`
+	Match  Match  `yaml:"match"`
+	Action string `yaml:"action"`
+	Kind   string `yaml:"kind"`  // 结果的检测类型，为空时按动作决定
+	Label  string `yaml:"label"` // 写入结果的标签
+	Link   string `yaml:"link"`  // follow-link 提取地址的正则，有分组时使用第一个分组
+
+	match  *matcher
+	linkRe *regexp.Regexp
+}
+
+// Match 匹配条件
+// 同一层中设置的条件全部满足才匹配（AND），all 中的条件全部满足，any 中的条件满足任意一个（OR），not 中的条件不满足
+type Match struct {
+	Status      []int             `yaml:"status"`      // 状态码等于其中任意一个
+	Headers     map[string]string `yaml:"headers"`     // 响应头包含子串，不区分大小写
+	HeaderRegex map[string]string `yaml:"headerRegex"` // 响应头匹配正则
+	Body        []string          `yaml:"body"`        // 响应体包含全部子串
+	BodyRegex   []string          `yaml:"bodyRegex"`   // 响应体匹配全部正则
+	All         []Match           `yaml:"all"`
+	Any         []Match           `yaml:"any"`
+	Not         *Match            `yaml:"not"`
+}
+
+// Response 规则匹配使用的响应
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       func() []byte // 只有规则用到响应体时才读取
+}
+
+// Engine 编译后的规则列表
+type Engine struct {
+	rules []*Rule
+}
+
+type matcher struct {
+	status   []int
+	headers  map[string]string
+	headerRe map[string]*regexp.Regexp
+	body     [][]byte
+	bodyRe   []*regexp.Regexp
+	all      []*matcher
+	any      []*matcher
+	not      *matcher
+}
+
+// 返回内置规则
+func Defaults() ([]Rule, error) {
+	var rules []Rule
+	if err := yaml.Unmarshal(defaultRules, &rules); err != nil {
+		return nil, fmt.Errorf("解析内置规则失败: %v", err)
+	}
+	return rules, nil
+}
+
+// 编译规则，withDefaults 为 true 时在 custom 之后追加内置规则
+func New(custom []Rule, withDefaults bool) (*Engine, error) {
+	rules := append([]Rule(nil), custom...)
+	if withDefaults {
+		defaults, err := Defaults()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, defaults...)
+	}
+	e := &Engine{}
+	for i := range rules {
+		r := &rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("规则 %s: %v", r.Name, err)
+		}
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+func (r *Rule) compile() error {
+	switch r.Action {
+	case ActionAccept, ActionReject, ActionDownloadStream, ActionDownloadTS, ActionFollowLink:
+	default:
+		return fmt.Errorf("未知的动作 %q", r.Action)
+	}
+	m, err := compileMatch(&r.Match)
+	if err != nil {
+		return err
+	}
+	r.match = m
+	if r.Action == ActionFollowLink {
+		pattern := r.Link
+		if pattern == "" {
+			pattern = defaultLinkPattern
+		}
+		if r.linkRe, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("link 正则错误: %v", err)
+		}
+	}
+	return nil
+}
+
+func compileMatch(m *Match) (*matcher, error) {
+	c := &matcher{status: m.Status}
+	if len(m.Headers) > 0 {
+		c.headers = make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			c.headers[k] = strings.ToLower(v)
+		}
+	}
+	if len(m.HeaderRegex) > 0 {
+		c.headerRe = make(map[string]*regexp.Regexp, len(m.HeaderRegex))
+		for k, v := range m.HeaderRegex {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return nil, fmt.Errorf("响应头 %s 正则错误: %v", k, err)
+			}
+			c.headerRe[k] = re
+		}
+	}
+	for _, s := range m.Body {
+		c.body = append(c.body, []byte(s))
+	}
+	for _, s := range m.BodyRegex {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("响应体正则错误: %v", err)
+		}
+		c.bodyRe = append(c.bodyRe, re)
+	}
+	for i := range m.All {
+		sub, err := compileMatch(&m.All[i])
+		if err != nil {
+			return nil, err
+		}
+		c.all = append(c.all, sub)
+	}
+	for i := range m.Any {
+		sub, err := compileMatch(&m.Any[i])
+		if err != nil {
+			return nil, err
+		}
+		c.any = append(c.any, sub)
+	}
+	if m.Not != nil {
+		sub, err := compileMatch(m.Not)
+		if err != nil {
+			return nil, err
+		}
+		c.not = sub
+	}
+	return c, nil
+}
+
+// 返回第一条匹配的规则，都不匹配时返回 nil
+func (e *Engine) Match(resp *Response) *Rule {
+	if e == nil {
+		return nil
+	}
+	for _, r := range e.rules {
+		if r.match.matches(resp) {
+			return r
+		}
+	}
+	return nil
+}
+
+// 按 link 正则从响应体中提取地址，没有找到时返回空字符串
+func (r *Rule) ExtractLink(body []byte) string {
+	if r.linkRe == nil {
+		return ""
+	}
+	m := r.linkRe.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	if len(m) > 1 {
+		return string(m[1])
+	}
+	return string(m[0])
+}
+
+// 先判断状态码、响应头和子条件，最后才读取响应体，避免为不匹配的流媒体响应读取数据
+func (m *matcher) matches(resp *Response) bool {
+	if len(m.status) > 0 && !slices.Contains(m.status, resp.StatusCode) {
+		return false
+	}
+	for k, v := range m.headers {
+		if !strings.Contains(strings.ToLower(resp.Header.Get(k)), v) {
+			return false
+		}
+	}
+	for k, re := range m.headerRe {
+		if !re.MatchString(resp.Header.Get(k)) {
+			return false
+		}
+	}
+	for _, sub := range m.all {
+		if !sub.matches(resp) {
+			return false
+		}
+	}
+	if len(m.any) > 0 {
+		ok := false
+		for _, sub := range m.any {
+			if sub.matches(resp) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if m.not != nil && m.not.matches(resp) {
+		return false
+	}
+	if len(m.body) > 0 || len(m.bodyRe) > 0 {
+		body := resp.Body()
+		for _, s := range m.body {
+			if !bytes.Contains(body, s) {
+				return false
+			}
+		}
+		for _, re := range m.bodyRe {
+			if !re.Match(body) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package scanner
+
+import (
+	"time"
+)
+
+// hostQueue 同一目标主机的待执行任务
+type hostQueue struct {
+	tasks     []Task
+	inflight  int       // 正在执行的任务数
+	lastStart time.Time // 最近一次开始执行的时间
+}
+
+// 设置单个目标主机的并发上限和两次请求的最小间隔，需在 Start 之前调用
+// limit 和 delay 都为 0 时不做限制，任务按入队顺序直接交给 worker
+func (wp *WorkerPool) SetHostPoliteness(limit int, delay time.Duration) {
+	wp.hostLimit = limit
+	wp.hostDelay = delay
+}
+
+// 是否启用单主机限制
+func (wp *WorkerPool) politeEnabled() bool {
+	return wp.hostLimit > 0 || wp.hostDelay > 0
+}
+
+// dispatch 从 TaskQueue 读取任务，按主机分组后轮流派发给 worker
+// 同一主机的并发数不超过 hostLimit，两次开始执行的间隔不小于 hostDelay
+func (wp *WorkerPool) dispatch() {
+	hosts := make(map[string]*hostQueue)
+	var order []string // 有待执行任务的主机，按轮询顺序排列
+	next := 0          // 下一次轮询的起点
+	pending := 0       // 已读入但尚未派发的任务数
+	running := 0       // 正在执行的任务数
+	input := wp.TaskQueue
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		if input == nil && pending == 0 && running == 0 {
+			close(wp.ready)
+			return
+		}
+
+		// 轮询选出一个可以立即执行的主机，同时清理已无待执行任务的主机
+		now := time.Now()
+		var candidate string
+		var wakeAt time.Time
+		for i := 0; i < len(order); {
+			idx := (next + i) % len(order)
+			host := order[idx]
+			q := hosts[host]
+			if q == nil || len(q.tasks) == 0 {
+				order = append(order[:idx], order[idx+1:]...)
+				if idx < next {
+					next--
+				}
+				if len(order) > 0 {
+					next %= len(order)
+				} else {
+					next = 0
+				}
+				continue
+			}
+			if wp.hostLimit > 0 && q.inflight >= wp.hostLimit {
+				i++
+				continue
+			}
+			if ready := q.lastStart.Add(wp.hostDelay); wp.hostDelay > 0 && ready.After(now) {
+				if wakeAt.IsZero() || ready.Before(wakeAt) {
+					wakeAt = ready
+				}
+				i++
+				continue
+			}
+			candidate = host
+			next = (idx + 1) % len(order)
+			break
+		}
+
+		var readyCh chan Task
+		var task Task
+		if candidate != "" {
+			readyCh = wp.ready
+			task = hosts[candidate].tasks[0]
+		}
+		in := input
+		if pending >= wp.maxPending {
+			in = nil // 待派发任务过多，暂停读取
+		}
+		var wake <-chan time.Time
+		if candidate == "" && !wakeAt.IsZero() {
+			timer.Reset(time.Until(wakeAt))
+			wake = timer.C
+		}
+
+		select {
+		case t, ok := <-in:
+			if !ok {
+				input = nil
+				break
+			}
+			q := hosts[t.IP]
+			if q == nil {
+				q = &hostQueue{}
+				hosts[t.IP] = q
+			}
+			if len(q.tasks) == 0 {
+				order = append(order, t.IP)
+			}
+			q.tasks = append(q.tasks, t)
+			pending++
+		case readyCh <- task:
+			q := hosts[candidate]
+			q.tasks[0] = Task{}
+			q.tasks = q.tasks[1:]
+			q.inflight++
+			q.lastStart = time.Now()
+			pending--
+			running++
+		case host := <-wp.done:
+			running--
+			q := hosts[host]
+			q.inflight--
+			if q.inflight == 0 && len(q.tasks) == 0 && wp.hostDelay == 0 {
+				delete(hosts, host)
+			} else if len(hosts) > 2*wp.maxPending {
+				// 有请求间隔时空闲主机需保留最近执行时间，数量过多时清理已过间隔的主机
+				purgeIdleHosts(hosts, wp.hostDelay)
+			}
+		case <-wake:
+		}
+		if wake != nil && !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// 清理没有任务且已超过请求间隔的主机
+func purgeIdleHosts(hosts map[string]*hostQueue, delay time.Duration) {
+	now := time.Now()
+	for host, q := range hosts {
+		if q.inflight == 0 && len(q.tasks) == 0 && now.Sub(q.lastStart) >= delay {
+			delete(hosts, host)
+		}
+	}
+}
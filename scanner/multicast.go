@@ -0,0 +1,100 @@
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/qist/iptv-static-scan/checkpoint"
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/multicast"
+	"github.com/qist/iptv-static-scan/network"
+	"github.com/qist/iptv-static-scan/output"
+)
+
+// 未配置时每台 udpxy 同时探测的组播路径数，udpxy 默认只允许 3 个客户端，默认逐个探测
+const defaultMulticastConcurrency = 1
+
+var (
+	sweepPaths []string // 发现 udpxy 后逐个探测的组播路径
+	swept      sync.Map // 已经扫过组播列表的 udpxy，同一台服务器只扫一次
+)
+
+// 加载组播扫描列表，未配置 multicastPaths 和 multicastFile 时不扫描
+func InitMulticastSweep(cfg *config.Config) error {
+	paths, err := multicast.SweepPaths(cfg)
+	if err != nil {
+		return err
+	}
+	sweepPaths = paths
+	return nil
+}
+
+// 对发现的 udpxy 服务器请求组播列表中的路径，找出正在播放的频道
+// 每个路径作为一个任务加入工作池，与其他任务一样受单主机限制和速率限制并计入进度，发现 udpxy 的任务不等待扫描完成
+// 这些任务属于发现该 udpxy 的断点单元，全部完成后该单元才会提交
+func SweepMulticast(wp *WorkerPool, t *network.Target, cfg *config.Config, successfulIPsCh chan<- *output.Result, unit *checkpoint.Unit) {
+	if len(sweepPaths) == 0 {
+		return
+	}
+	key := fmt.Sprintf("%s://%s:%d|%s", t.Scheme, t.IP, t.Port, t.Host)
+	if _, loaded := swept.LoadOrStore(key, true); loaded {
+		return
+	}
+
+	var paths []string
+	for _, urlPath := range sweepPaths {
+		if urlPath != t.Path {
+			paths = append(paths, urlPath) // 发现 udpxy 的路径已经下载过
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	concurrency := min(sweepConcurrency(t, cfg), len(paths))
+	log.Printf("udpxy %s:%d 开始扫描 %d 个组播地址，并发 %d\n", t.IP, t.Port, len(paths), concurrency)
+	wp.Progress.AddTotal(int64(len(paths)))
+
+	// 同时只下发 concurrency 个路径，一个完成后再下发下一个，同一台 udpxy 的并发请求不超过剩余的客户端名额
+	var next, finished atomic.Int64
+	var launch func()
+	launch = func() {
+		i := int(next.Add(1)) - 1
+		if i >= len(paths) {
+			return
+		}
+		st := t.WithPath(paths[i])
+		unit.Add()
+		wp.AddTaskAsync(Task{
+			IP: st.IP,
+			Executor: func(ip string) {
+				network.DownloadTarget(st, output.KindUdpxy, cfg, successfulIPsCh)
+				if finished.Add(1) == int64(len(paths)) {
+					log.Printf("udpxy %s:%d 组播扫描完成\n", t.IP, t.Port)
+				}
+				launch()
+			},
+			Unit: unit,
+		})
+	}
+	for range concurrency {
+		launch()
+	}
+}
+
+// 扫描组播列表的并发数，不超过 hostConcurrency
+// 读取到 udpxy 状态页中的最大客户端数时，不超过剩余的客户端名额，避免探测被拒绝而误判为无信号
+func sweepConcurrency(t *network.Target, cfg *config.Config) int {
+	n := cfg.MulticastConcurrency
+	if n <= 0 {
+		n = defaultMulticastConcurrency
+	}
+	if cfg.HostConcurrency > 0 {
+		n = min(n, cfg.HostConcurrency)
+	}
+	if info := network.UdpxyStatus(t, "", cfg); info != nil && info.MaxClients > 0 {
+		n = min(n, max(info.MaxClients-info.ActiveClients, 1))
+	}
+	return n
+}
@@ -0,0 +1,91 @@
+package scanner
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/network"
+	"github.com/qist/iptv-static-scan/util"
+)
+
+var (
+	preScanSem  chan struct{} // 限制所有预扫描连接的总并发
+	preScanOnce sync.Once
+)
+
+// PortSet 预扫描得到的开放端口，nil 表示未启用预扫描，所有端口都视为开放
+type PortSet map[int]bool
+
+// 判断端口是否需要进入 HTTP 探测
+func (s PortSet) Open(port int) bool {
+	if s == nil {
+		return true
+	}
+	return s[port]
+}
+
+// 对 ports、非循环端口路径中的端口做 TCP 预扫描，未启用预扫描时返回 nil
+// source 为该主机使用的出口地址，与后续 HTTP 请求保持一致
+func PreScanTargetPorts(host string, source string, cfg *config.Config) PortSet {
+	if !cfg.PreScan {
+		return nil
+	}
+	ports := util.ExpandPorts(cfg.Ports)
+	for _, nonPortPath := range cfg.NonPortsPath {
+		parts := strings.SplitN(nonPortPath, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if port, err := strconv.Atoi(parts[0]); err == nil {
+			ports = append(ports, port)
+		}
+	}
+	return PreScanPorts(host, ports, source, cfg)
+}
+
+// 并发对指定端口做 TCP 连接，返回开放的端口，未启用预扫描时返回 nil
+func PreScanPorts(host string, ports []int, source string, cfg *config.Config) PortSet {
+	if !cfg.PreScan {
+		return nil
+	}
+	preScanOnce.Do(func() {
+		concurrency := cfg.PreScanConcurrency
+		if concurrency <= 0 {
+			concurrency = 1000
+		}
+		preScanSem = make(chan struct{}, concurrency)
+	})
+	timeout := time.Duration(cfg.PreScanTimeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 500 * time.Millisecond
+	}
+
+	open := make(PortSet)
+	seen := make(map[int]bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, port := range ports {
+		if seen[port] {
+			continue // 重复端口只扫描一次
+		}
+		seen[port] = true
+		wg.Add(1)
+		preScanSem <- struct{}{}
+		go func(port int) {
+			defer wg.Done()
+			defer func() { <-preScanSem }()
+			if network.TCPPortOpen(host, port, source, timeout) {
+				mu.Lock()
+				open[port] = true
+				mu.Unlock()
+				log.Printf("预扫描 %s:%d 端口开放\n", host, port)
+			}
+		}(port)
+	}
+	wg.Wait()
+	return open
+}
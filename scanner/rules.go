@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/qist/iptv-static-scan/checkpoint"
+	"github.com/qist/iptv-static-scan/config"
+	"github.com/qist/iptv-static-scan/network"
+	"github.com/qist/iptv-static-scan/output"
+	"github.com/qist/iptv-static-scan/rules"
+)
+
+// follow-link 最多连续跟随的次数，防止页面互相引用
+const maxFollowDepth = 3
+
+var ruleEngine *rules.Engine
+
+// 编译配置文件中的检测规则和内置规则
+func InitRules(cfg *config.Config) error {
+	var custom []rules.Rule
+	if !cfg.Rules.IsZero() {
+		if err := cfg.Rules.Decode(&custom); err != nil {
+			return fmt.Errorf("解析检测规则失败: %v", err)
+		}
+	}
+	e, err := rules.New(custom, !cfg.DisableDefaultRules)
+	if err != nil {
+		return err
+	}
+	ruleEngine = e
+	return nil
+}
+
+// 按第一条匹配的规则处理响应
+func applyRules(wp *WorkerPool, p *network.Probe, cfg *config.Config, successfulIPsCh chan<- *output.Result, unit *checkpoint.Unit, depth int) {
+	header := p.Resp.Header
+	if header.Get("Content-Type") == "" {
+		// 没有 Content-Type 时根据响应体前缀判断
+		if sniffed := network.SniffContentType(p); sniffed != "" {
+			header = header.Clone()
+			header.Set("Content-Type", sniffed)
+		}
+	}
+	rule := ruleEngine.Match(&rules.Response{
+		StatusCode: p.Resp.StatusCode,
+		Header:     header,
+		Body: func() []byte {
+			body, _ := p.ReadText()
+			return body
+		},
+	})
+	if rule == nil {
+		log.Printf("访问:%s, 状态码: %d, 没有匹配的检测规则\n", p.URL, p.Resp.StatusCode)
+		return
+	}
+	// follow-link 得到的目标沿用上一条规则的标签，除非当前规则设置了新的标签
+	if rule.Label != "" {
+		p.Target.Label = rule.Label
+	}
+
+	switch rule.Action {
+	case rules.ActionReject:
+		log.Printf("访问:%s, 状态码: %d, 按规则 %s 丢弃\n", p.URL, p.Resp.StatusCode, rule.Name)
+	case rules.ActionAccept:
+		log.Printf("访问 %s 成功, 匹配规则 %s, 耗时: %v\n", p.URL, rule.Name, p.Latency)
+		network.Accept(p, kindOr(rule.Kind, output.KindPage), cfg, successfulIPsCh)
+	case rules.ActionDownloadStream:
+		kind := kindOr(rule.Kind, output.KindVideo)
+		log.Printf("访问 %s:%d 成功, 匹配规则 %s\n", p.Target.IP, p.Target.Port, rule.Name)
+		network.DownloadStream(p, kind, cfg, successfulIPsCh)
+		if kind == output.KindUdpxy {
+			// 先断开当前连接释放 udpxy 的客户端名额，再把组播列表中的其他频道加入工作池
+			p.Close()
+			SweepMulticast(wp, p.Target, cfg, successfulIPsCh, unit)
+		}
+	case rules.ActionDownloadTS:
+		network.CheckMPEGURLContent(p, cfg, successfulIPsCh)
+	case rules.ActionFollowLink:
+		followLink(wp, p, rule, cfg, successfulIPsCh, unit, depth)
+	}
+}
+
+// 从响应体中提取地址，请求后重新匹配规则
+func followLink(wp *WorkerPool, p *network.Probe, rule *rules.Rule, cfg *config.Config, successfulIPsCh chan<- *output.Result, unit *checkpoint.Unit, depth int) {
+	if depth >= maxFollowDepth {
+		log.Printf("%s 跟随次数超过 %d 次，不再跟随\n", p.URL, maxFollowDepth)
+		return
+	}
+	body, err := p.ReadText()
+	if err != nil {
+		log.Printf("读取 %s 响应体失败: %v\n", p.URL, err)
+		return
+	}
+	link := rule.ExtractLink(body)
+	if link == "" {
+		log.Printf("%s 中没有找到可跟随的地址\n", p.URL)
+		return
+	}
+	np, err := network.FetchLink(p.Target, link, cfg)
+	if err != nil {
+		log.Printf("请求 %s 中的地址 %s 失败: %v\n", p.URL, link, err)
+		return
+	}
+	defer np.Close()
+	log.Printf("跟随 %s 中的地址 %s\n", p.URL, np.URL)
+	applyRules(wp, np, cfg, successfulIPsCh, unit, depth+1)
+}
+
+func kindOr(kind, def string) string {
+	if kind != "" {
+		return kind
+	}
+	return def
+}
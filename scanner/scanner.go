@@ -1,49 +1,116 @@
 package scanner
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"log"
+	"math"
+	"math/big"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/qist/iptv-static-scan/checkpoint"
 	"github.com/qist/iptv-static-scan/config"
 	"github.com/qist/iptv-static-scan/domain"
 	"github.com/qist/iptv-static-scan/network"
+	"github.com/qist/iptv-static-scan/output"
+	"github.com/qist/iptv-static-scan/progress"
+	"github.com/qist/iptv-static-scan/resolver"
 	"github.com/qist/iptv-static-scan/util"
 )
 
 // 任务结构体，代表一个工作单元
 type Task struct {
-	IP       string       // IP 地址
-	Executor func(string) // 执行函数，接收 IP 作为参数
+	IP       string           // IP 地址
+	Executor func(string)     // 执行函数，接收 IP 作为参数
+	Unit     *checkpoint.Unit // 所属断点单元，执行完成后标记
 }
 
 // WorkerPool 结构体，表示一个执行任务的工作池
 type WorkerPool struct {
-	wg        sync.WaitGroup
-	TaskQueue chan Task
-	poolSize  int
+	wg         sync.WaitGroup
+	TaskQueue  chan Task
+	poolSize   int
+	Checkpoint *checkpoint.Tracker // 断点记录，未启用时为 nil
+	Progress   *progress.Counter   // 进度计数，未启用时为 nil
+
+	// 单主机限制，见 SetHostPoliteness
+	hostLimit  int
+	hostDelay  time.Duration
+	maxPending int         // 调度器最多缓存的待派发任务数
+	ready      chan Task   // 调度器派发给 worker 的任务
+	done       chan string // worker 执行完成后通知调度器的主机
+
+	stop     chan struct{} // 扫描中断时关闭，不再执行新任务
+	stopOnce sync.Once
+	tasks    sync.WaitGroup // 已下发但尚未执行完的任务，包括执行中追加的任务
 }
 
 // 创建一个指定大小的工作池
 func NewWorkerPool(poolSize, bufferSize int) *WorkerPool {
 	return &WorkerPool{
-		TaskQueue: make(chan Task, bufferSize),
-		poolSize:  poolSize,
+		TaskQueue:  make(chan Task, bufferSize),
+		poolSize:   poolSize,
+		maxPending: bufferSize,
+		stop:       make(chan struct{}),
 	}
 }
 
-// 添加任务到工作池
+// 添加任务到工作池，扫描中断后不再添加，任务所在的断点单元不会提交
 func (wp *WorkerPool) AddTask(task Task) {
-	wp.TaskQueue <- task
+	wp.tasks.Add(1)
+	wp.enqueue(task)
+}
+
+// 在任务执行过程中追加任务，另起协程写入任务队列，当前任务不等待队列空位
+func (wp *WorkerPool) AddTaskAsync(task Task) {
+	wp.tasks.Add(1)
+	go wp.enqueue(task)
+}
+
+func (wp *WorkerPool) enqueue(task Task) {
+	select {
+	case <-wp.stop:
+		task.Unit.Abort()
+		wp.tasks.Done()
+	case wp.TaskQueue <- task:
+	}
+}
+
+// 等待所有任务（包括执行中追加的任务）下发并执行完后关闭任务队列
+func (wp *WorkerPool) Close() {
+	wp.tasks.Wait()
+	close(wp.TaskQueue)
+}
+
+// 中断扫描：不再接收和执行新任务，正在执行的任务继续完成
+func (wp *WorkerPool) Stop() {
+	wp.stopOnce.Do(func() { close(wp.stop) })
+}
+
+// 扫描是否已中断
+func (wp *WorkerPool) Stopped() bool {
+	select {
+	case <-wp.stop:
+		return true
+	default:
+		return false
+	}
 }
 
 // 启动工作池并执行任务
 func (wp *WorkerPool) Start() {
+	if wp.politeEnabled() {
+		wp.ready = make(chan Task)
+		wp.done = make(chan string)
+		go wp.dispatch()
+	}
 	wp.wg.Add(wp.poolSize)
 	for i := 0; i < wp.poolSize; i++ {
 		go wp.worker()
@@ -54,9 +121,29 @@ func (wp *WorkerPool) Start() {
 func (wp *WorkerPool) worker() {
 	defer wp.wg.Done()
 
+	if wp.ready != nil {
+		for task := range wp.ready {
+			wp.run(task)
+			wp.done <- task.IP
+		}
+		return
+	}
 	for task := range wp.TaskQueue {
-		task.Executor(task.IP)
+		wp.run(task)
+	}
+}
+
+// 执行单个任务并记录完成，扫描中断后队列中剩余的任务不再执行
+func (wp *WorkerPool) run(task Task) {
+	defer wp.tasks.Done()
+	if wp.Stopped() {
+		task.Unit.Abort()
+		return
 	}
+	wp.Progress.SetCurrent(task.IP)
+	task.Executor(task.IP)
+	task.Unit.Done()
+	wp.Progress.Done()
 }
 
 // 等待所有任务完成
@@ -64,177 +151,94 @@ func (wp *WorkerPool) Wait() {
 	wp.wg.Wait()
 }
 
-// 检查IP和端口是否可访问
-func CheckIPPort(ip string, port int, urlPath string, cfg *config.Config, successfulIPsCh chan<- string) {
-	url := fmt.Sprintf("http://%s:%d/%s", ip, port, urlPath)
-	client := network.CreateHTTPClient(cfg)    // 复用创建HTTP客户端的代码
-	req, err := network.CreateHTTPRequest(url) // 复用创建HTTP请求的代码
-	if err != nil {
-		log.Printf("创建请求失败: %v\n", err)
-		return
-	}
-	req.Header = cfg.UAHeaders
-
-	resp, err := client.Do(req)
+// 检查IP和端口是否可访问，同一目标只请求一次，响应交给 ConfirmAccess 继续检测
+// unit 为该任务所属的断点单元，检测中追加的任务（如组播扫描）也属于该单元
+func CheckIPPort(wp *WorkerPool, t *network.Target, cfg *config.Config, successfulIPsCh chan<- *output.Result, unit *checkpoint.Unit) {
+	p, err := network.Fetch(t, cfg)
 	if err != nil {
 		if strings.Contains(err.Error(), "redirected to HTTPS") {
 			// 已经在CheckRedirect中处理日志记录
-			log.Printf("ip: %s 已按指示断开HTTPS重定向的连接: %v\n", ip, err)
+			log.Printf("ip: %s 已按指示断开HTTPS重定向的连接: %v\n", t.IP, err)
 		} else {
 			log.Printf("请求失败: %v\n", err)
 		}
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 200 {
-		// 调用 confirmAccess 函数，传递 IP、端口和 URL 路径
-		ConfirmAccess(ip, port, urlPath, cfg, successfulIPsCh)
-	} else {
-		log.Printf("访问:%s, 状态码: %d\n", url, resp.StatusCode)
-		return // 状态码不为200时直接返回
-	}
-}
-
-// 确认访问成功后的操作
-func ConfirmAccess(ip string, port int, urlPath string, cfg *config.Config, successfulIPsCh chan<- string) {
-	url := fmt.Sprintf("http://%s:%d/%s", ip, port, urlPath)
-	client := network.CreateHTTPClient(cfg)    // 复用创建HTTP客户端的代码
-	req, err := network.CreateHTTPRequest(url) // 复用创建HTTP请求的代码
-	if err != nil {
-		log.Printf("创建请求失败: %v\n", err)
-		return
-	}
-	req.Header = cfg.UAHeaders
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("发送请求失败: %v\n", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 200 {
-		contentHeader := resp.Header.Get("Content-Type")
-		serverHeader := resp.Header.Get("Server")
+	defer p.Close()
 
-		if serverHeader != "" && strings.Contains(serverHeader, "udpxy") {
-			log.Printf("访问 %s:%d 成功, Server: udpxy\n", ip, port)
-			network.DownloadStream(ip, port, urlPath, cfg, successfulIPsCh)
-		}
+	// 状态码也由检测规则判断，内置规则丢弃状态码不为 200 的响应
+	ConfirmAccess(wp, p, cfg, successfulIPsCh, unit)
+}
 
-		if contentHeader != "" {
-			if strings.Contains(contentHeader, "x-flv") || strings.Contains(contentHeader, "video") {
-				network.DownloadStream(ip, port, urlPath, cfg, successfulIPsCh)
-			} else if strings.Contains(contentHeader, "mpegurl") {
-				network.CheckMPEGURLContent(ip, port, urlPath, cfg, successfulIPsCh)
-			} else if strings.Contains(contentHeader, "text") {
-				network.MkHTMLContent(ip, port, urlPath, cfg, successfulIPsCh)
-			} else if strings.Contains(contentHeader, "application/json") {
-				network.MkHTMLContent(ip, port, urlPath, cfg, successfulIPsCh)
-			}
-		}
-	} else {
-		log.Printf("请求 %s 失败, 状态码: %d\n", url, resp.StatusCode)
-		return // 状态码不为200时直接返回
-	}
+// 按检测规则确定处理方式，响应体在各检测阶段之间共用
+func ConfirmAccess(wp *WorkerPool, p *network.Probe, cfg *config.Config, successfulIPsCh chan<- *output.Result, unit *checkpoint.Unit) {
+	applyRules(wp, p, cfg, successfulIPsCh, unit, 0)
 }
 
 // 解析CIDR文件并添加任务到 worker pool 处理
-func AddTaskToPool(wp *WorkerPool, ip string, port int, urlPath string, cfg *config.Config, successfulIPsCh chan<- string) {
+func AddTaskToPool(wp *WorkerPool, target *network.Target, cfg *config.Config, successfulIPsCh chan<- *output.Result, unit *checkpoint.Unit) {
+	// 按配置补全协议、Host 和 SNI
+	target.ApplyConfig(cfg)
+	unit.Add()
 	// 添加任务到工作池
 	wp.AddTask(Task{
-		IP:       ip,
-		Executor: func(ip string) { CheckIPPort(ip, port, urlPath, cfg, successfulIPsCh) },
+		IP: target.IP,
+		Executor: func(ip string) {
+			// 配置了 vhosts 时同一目标依次使用每个虚拟主机探测，进度按请求计算，第一个请求在任务完成时计入
+			for i, t := range network.VhostTargets(target, cfg) {
+				CheckIPPort(wp, t, cfg, successfulIPsCh, unit)
+				if i > 0 {
+					wp.Progress.Done()
+				}
+			}
+		},
+		Unit: unit,
 	})
 }
 
-// 处理单个CIDR
-func ProcessCIDR(workerPool *WorkerPool, cidr string, cfg *config.Config, successfulIPsCh chan<- string) error {
+// 处理单个CIDR，source 为 CIDR 文件中的原始行，base 为该 CIDR 在文件中的断点位置（行号和区间序号）
+func ProcessCIDR(workerPool *WorkerPool, cidr string, source string, base checkpoint.Position, cfg *config.Config, successfulIPsCh chan<- *output.Result) error {
+	cp := workerPool.Checkpoint
+	counter := workerPool.Progress
+
 	// 创建一个带有缓冲区的通道来限制并发的 goroutine 数量
 	sem := make(chan struct{}, cfg.MaxConcurrentRequest)
 
 	// 判断是否为域名
 	switch domain.IsDomain(cidr) {
 	case 1:
-		// 如果是域名，直接生成 URL，受 MaxConcurrentRequest 限制
-		var wg sync.WaitGroup
-		for _, port := range util.ExpandPorts(cfg.Ports) {
-			for _, urlPath := range cfg.URLPaths {
-				wg.Add(1)
-				sem <- struct{}{}
-				go func(port int, urlPath string) {
-					defer wg.Done()
-					defer func() { <-sem }()
-
-					// 获取当前时间戳，并截取前9位
-					timestamp := int(time.Now().Unix())
-					timestampStr := fmt.Sprintf("%d", timestamp)[:9]
-					timestampInt, err := strconv.Atoi(timestampStr)
-					if err != nil {
-						log.Fatalf("转换时间戳失败: %v", err)
-					}
-
-					// 将截取的时间戳减去5秒
-					timestampMinus5 := timestampInt - 5
-
-					// 获取当前日期时间，并按照指定格式格式化
-					timeFirst := time.Now().Format("2006010215")
-					// 动态替换 URL 中的变量
-					if strings.Contains(urlPath, "{timeFirst}") || strings.Contains(urlPath, "{timestampMinus5}") {
-						urlPath = strings.Replace(urlPath, "{timeFirst}", timeFirst, -1)
-						urlPath = strings.Replace(urlPath, "{timestampMinus5}", strconv.Itoa(timestampMinus5), -1)
-					}
-					AddTaskToPool(workerPool, cidr, port, urlPath, cfg, successfulIPsCh)
-				}(port, urlPath)
-			}
+		if !cfg.ExpandDomains {
+			// 如果是域名，直接生成 URL，受 MaxConcurrentRequest 限制
+			processHost(workerPool, cidr, network.Target{Source: source}, base, sem, cfg, successfulIPsCh)
+			break
 		}
-		// 处理非循环端口
-		for _, nonPortPath := range cfg.NonPortsPath {
-			parts := strings.SplitN(nonPortPath, "/", 2)
-			if len(parts) == 2 {
-				nonPortStr := parts[0]
-				nonPath := parts[1]
-
-				wg.Add(1)
-				sem <- struct{}{}
-				go func(nonPortStr, nonPath string) {
-					defer wg.Done()
-					defer func() { <-sem }()
-
-					// 处理非循环端口
-					nonPort, err := strconv.Atoi(nonPortStr)
-					if err != nil {
-						log.Printf("无效的非循环端口: %s, 错误: %v", nonPortStr, err)
-						return
-					}
-
-					// 获取当前时间戳，并截取前9位
-					timestamp := int(time.Now().Unix())
-					timestampStr := fmt.Sprintf("%d", timestamp)[:9]
-					timestampInt, err := strconv.Atoi(timestampStr)
-					if err != nil {
-						log.Fatalf("转换时间戳失败: %v", err)
-					}
-
-					// 将截取的时间戳减去5秒
-					timestampMinus5 := timestampInt - 5
-
-					// 获取当前日期时间，并按照指定格式格式化
-					timeFirst := time.Now().Format("2006010215")
-					// 动态替换 URL 中的变量
-					if strings.Contains(nonPath, "{timeFirst}") || strings.Contains(nonPath, "{timestampMinus5}") {
-						nonPath = strings.Replace(nonPath, "{timeFirst}", timeFirst, -1)
-						nonPath = strings.Replace(nonPath, "{timestampMinus5}", strconv.Itoa(timestampMinus5), -1)
-					}
-					// 添加任务到 worker pool
-					AddTaskToPool(workerPool, cidr, nonPort, nonPath, cfg, successfulIPsCh)
-				}(nonPortStr, nonPath)
-			} else {
-				log.Printf("无效的非循环端口路径格式: %s", nonPortPath)
+		// 展开域名的所有 A/AAAA 记录，逐个 IP 扫描，请求的 Host 头仍为域名
+		ips, err := resolver.LookupIP(context.Background(), cidr)
+		if err != nil {
+			counter.Skip(util.PerIPTasks(cfg))
+			return fmt.Errorf("解析域名 %s 失败：%v", cidr, err)
+		}
+		// 轮询 DNS 每次返回的顺序不同，排序去重后断点中的序号才对应同一个 IP
+		ips = sortIPs(ips)
+		// 任务总数按一个目标统计，补上其余 IP 的任务数
+		counter.AddTotal(int64(len(ips)-1) * util.PerIPTasks(cfg))
+		for i, ip := range ips {
+			if workerPool.Stopped() {
+				break
+			}
+			pos := base
+			pos.Sub = i
+			if cp.SkipSub(pos.Line, pos.Sub) {
+				counter.Skip(util.PerIPTasks(cfg))
+				continue
 			}
+			host := ip.String()
+			if IsIPv6(ip) {
+				host = fmt.Sprintf("[%s]", host)
+			}
+			log.Printf("域名 %s 解析到 %s\n", cidr, host)
+			processHost(workerPool, host, network.Target{Source: source, Domain: cidr}, pos, sem, cfg, successfulIPsCh)
 		}
-		wg.Wait()
 	case 2:
 		// 2. 判断是否是单个 IP
 		if IsSingleIP(cidr) {
@@ -250,17 +254,37 @@ func ProcessCIDR(workerPool *WorkerPool, cidr string, cfg *config.Config, succes
 		completed := false
 		startIP := ipNet.IP.Mask(ipNet.Mask) // 初始 IP
 		limit := cfg.MaxConcurrentRequest    // 每次生成的 IP 数量
+		perIP := util.PerIPTasks(cfg)
+		// 断点恢复时直接跳到上次的批次
+		if resumeIP := cp.ResumeStartIP(base.Line, base.Sub); resumeIP != nil && ipNet.Contains(resumeIP) {
+			if resumeIP.To4() != nil && len(startIP) == net.IPv4len {
+				resumeIP = resumeIP.To4()
+			}
+			counter.Skip(skippedTasks(hostsBetween(startIP, resumeIP), util.PerIPRequests(cfg)))
+			startIP = resumeIP
+		}
 		// log.Printf("初始ip: %s\n", startIP)
-		for !completed {
+		for !completed && !workerPool.Stopped() {
 			// 生成指定数量的 IP 地址
-			ips, isCompleted := GenerateLimitedIPsFromCIDR(startIP, ipNet, limit)
+			ips, nextIP, isCompleted := GenerateLimitedIPsFromCIDR(startIP, ipNet, limit)
 			if len(ips) == 0 {
 				return nil // 如果 IP 地址列表为空，则直接返回
 			}
 
 			// 将批次的 IP 地址并行分配给 worker pool 处理
+			// 每个 IP 一个断点单元，TaskIndex 为该 IP 最后一个任务在批次中的序号，恢复时跳过已完成的 IP
 			var wg sync.WaitGroup
-			for _, ip := range ips {
+			for j, ip := range ips {
+				pos := base
+				pos.StartIP = startIP.String()
+				pos.TaskIndex = int(int64(j+1)*perIP - 1)
+				if cp.Skip(pos) {
+					// 该 IP 上次已完成
+					counter.Skip(util.PerIPRequests(cfg))
+					continue
+				}
+				unit := cp.Begin(pos)
+
 				// log.Printf("eee: %s\n", ips)
 				if IsIPv6(ipNet.IP) {
 					ip = fmt.Sprintf("[%s]", ip)
@@ -271,38 +295,38 @@ func ProcessCIDR(workerPool *WorkerPool, cidr string, cfg *config.Config, succes
 				go func(ip string) {
 					defer wg.Done()
 					defer func() { <-sem }()
+					defer unit.Seal()
 
-					// 设置超时上下文
-					ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.TimeOut)*time.Second)
-					defer cancel()
+					// 同一 IP 的预扫描和请求使用同一个出口地址
+					srcAddr := network.NextSourceAddr()
+					// TCP 预扫描，只对开放的端口发起 HTTP 请求
+					openPorts := PreScanTargetPorts(ip, srcAddr, cfg)
 
 					for _, port := range util.ExpandPorts(cfg.Ports) {
+						if !openPorts.Open(port) {
+							counter.Skip(int64(len(cfg.URLPaths)) * util.VhostCount(cfg))
+							continue
+						}
 						for _, urlPath := range cfg.URLPaths {
-							select {
-							case <-ctx.Done():
-								log.Printf("处理 IP %s 超时", ip)
-								return
-							default:
-								// 获取当前时间戳，并截取前9位
-								timestamp := int(time.Now().Unix())
-								timestampStr := fmt.Sprintf("%d", timestamp)[:9]
-								timestampInt, err := strconv.Atoi(timestampStr)
-								if err != nil {
-									log.Fatalf("转换时间戳失败: %v", err)
-								}
-
-								// 将截取的时间戳减去5秒
-								timestampMinus5 := timestampInt - 5
-
-								// 获取当前日期时间，并按照指定格式格式化
-								timeFirst := time.Now().Format("2006010215")
-								// 动态替换 URL 中的变量
-								if strings.Contains(urlPath, "{timeFirst}") || strings.Contains(urlPath, "{timestampMinus5}") {
-									urlPath = strings.Replace(urlPath, "{timeFirst}", timeFirst, -1)
-									urlPath = strings.Replace(urlPath, "{timestampMinus5}", strconv.Itoa(timestampMinus5), -1)
-								}
-								AddTaskToPool(workerPool, ip, port, urlPath, cfg, successfulIPsCh)
+							// 获取当前时间戳，并截取前9位
+							timestamp := int(time.Now().Unix())
+							timestampStr := fmt.Sprintf("%d", timestamp)[:9]
+							timestampInt, err := strconv.Atoi(timestampStr)
+							if err != nil {
+								log.Fatalf("转换时间戳失败: %v", err)
+							}
+
+							// 将截取的时间戳减去5秒
+							timestampMinus5 := timestampInt - 5
+
+							// 获取当前日期时间，并按照指定格式格式化
+							timeFirst := time.Now().Format("2006010215")
+							// 动态替换 URL 中的变量
+							if strings.Contains(urlPath, "{timeFirst}") || strings.Contains(urlPath, "{timestampMinus5}") {
+								urlPath = strings.Replace(urlPath, "{timeFirst}", timeFirst, -1)
+								urlPath = strings.Replace(urlPath, "{timestampMinus5}", strconv.Itoa(timestampMinus5), -1)
 							}
+							AddTaskToPool(workerPool, &network.Target{IP: ip, Port: port, Path: urlPath, Source: source, SourceAddr: srcAddr}, cfg, successfulIPsCh, unit)
 						}
 					}
 					// 处理非循环端口
@@ -318,32 +342,30 @@ func ProcessCIDR(workerPool *WorkerPool, cidr string, cfg *config.Config, succes
 								log.Printf("无效的非循环端口: %s, 错误: %v", nonPortStr, err)
 								continue
 							}
-							select {
-							case <-ctx.Done():
-								log.Printf("处理 IP %s 超时", ip)
-								return
-							default:
-								// 获取当前时间戳，并截取前9位
-								timestamp := int(time.Now().Unix())
-								timestampStr := fmt.Sprintf("%d", timestamp)[:9]
-								timestampInt, err := strconv.Atoi(timestampStr)
-								if err != nil {
-									log.Fatalf("转换时间戳失败: %v", err)
-								}
-
-								// 将截取的时间戳减去5秒
-								timestampMinus5 := timestampInt - 5
-
-								// 获取当前日期时间，并按照指定格式格式化
-								timeFirst := time.Now().Format("2006010215")
-								// 动态替换 URL 中的变量
-								if strings.Contains(nonPath, "{timeFirst}") || strings.Contains(nonPath, "{timestampMinus5}") {
-									nonPath = strings.Replace(nonPath, "{timeFirst}", timeFirst, -1)
-									nonPath = strings.Replace(nonPath, "{timestampMinus5}", strconv.Itoa(timestampMinus5), -1)
-								}
-								// 添加任务到 worker pool
-								AddTaskToPool(workerPool, ip, nonPort, nonPath, cfg, successfulIPsCh)
+							if !openPorts.Open(nonPort) {
+								counter.Skip(util.VhostCount(cfg))
+								continue
+							}
+							// 获取当前时间戳，并截取前9位
+							timestamp := int(time.Now().Unix())
+							timestampStr := fmt.Sprintf("%d", timestamp)[:9]
+							timestampInt, err := strconv.Atoi(timestampStr)
+							if err != nil {
+								log.Fatalf("转换时间戳失败: %v", err)
 							}
+
+							// 将截取的时间戳减去5秒
+							timestampMinus5 := timestampInt - 5
+
+							// 获取当前日期时间，并按照指定格式格式化
+							timeFirst := time.Now().Format("2006010215")
+							// 动态替换 URL 中的变量
+							if strings.Contains(nonPath, "{timeFirst}") || strings.Contains(nonPath, "{timestampMinus5}") {
+								nonPath = strings.Replace(nonPath, "{timeFirst}", timeFirst, -1)
+								nonPath = strings.Replace(nonPath, "{timestampMinus5}", strconv.Itoa(timestampMinus5), -1)
+							}
+							// 添加任务到 worker pool
+							AddTaskToPool(workerPool, &network.Target{IP: ip, Port: nonPort, Path: nonPath, Source: source, SourceAddr: srcAddr}, cfg, successfulIPsCh, unit)
 						} else {
 							log.Printf("无效的非循环端口路径格式: %s", nonPortPath)
 						}
@@ -352,8 +374,8 @@ func ProcessCIDR(workerPool *WorkerPool, cidr string, cfg *config.Config, succes
 			}
 			wg.Wait()
 
-			// 递增 IP 地址，准备生成下一批 IP 地址
-			startIP = IncrementAndCopyIP(startIP, limit)
+			// 从下一个未处理的 IP 开始生成下一批 IP 地址
+			startIP = nextIP
 			completed = isCompleted
 		}
 	}
@@ -361,6 +383,145 @@ func ProcessCIDR(workerPool *WorkerPool, cidr string, cfg *config.Config, succes
 	return nil
 }
 
+// 扫描单个主机（域名或域名展开出的 IP）的所有端口和路径，tmpl 为目标的公共字段
+// 任务按端口×路径的序号记录断点，base 为该主机的断点位置
+func processHost(workerPool *WorkerPool, host string, tmpl network.Target, base checkpoint.Position, sem chan struct{}, cfg *config.Config, successfulIPsCh chan<- *output.Result) {
+	cp := workerPool.Checkpoint
+	counter := workerPool.Progress
+
+	var wg sync.WaitGroup
+	// 同一目标的预扫描和请求使用同一个出口地址
+	srcAddr := network.NextSourceAddr()
+	// TCP 预扫描，只对开放的端口发起 HTTP 请求
+	openPorts := PreScanTargetPorts(host, srcAddr, cfg)
+	taskIndex := 0
+	for _, port := range util.ExpandPorts(cfg.Ports) {
+		for _, urlPath := range cfg.URLPaths {
+			pos := base
+			pos.TaskIndex = taskIndex
+			taskIndex++
+			if cp.Skip(pos) || !openPorts.Open(port) {
+				counter.Skip(1)
+				continue
+			}
+			unit := cp.Begin(pos)
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(port int, urlPath string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				defer unit.Seal()
+
+				// 获取当前时间戳，并截取前9位
+				timestamp := int(time.Now().Unix())
+				timestampStr := fmt.Sprintf("%d", timestamp)[:9]
+				timestampInt, err := strconv.Atoi(timestampStr)
+				if err != nil {
+					log.Fatalf("转换时间戳失败: %v", err)
+				}
+
+				// 将截取的时间戳减去5秒
+				timestampMinus5 := timestampInt - 5
+
+				// 获取当前日期时间，并按照指定格式格式化
+				timeFirst := time.Now().Format("2006010215")
+				// 动态替换 URL 中的变量
+				if strings.Contains(urlPath, "{timeFirst}") || strings.Contains(urlPath, "{timestampMinus5}") {
+					urlPath = strings.Replace(urlPath, "{timeFirst}", timeFirst, -1)
+					urlPath = strings.Replace(urlPath, "{timestampMinus5}", strconv.Itoa(timestampMinus5), -1)
+				}
+				AddTaskToPool(workerPool, hostTarget(tmpl, host, port, urlPath, srcAddr), cfg, successfulIPsCh, unit)
+			}(port, urlPath)
+		}
+	}
+	// 处理非循环端口
+	for _, nonPortPath := range cfg.NonPortsPath {
+		parts := strings.SplitN(nonPortPath, "/", 2)
+		if len(parts) == 2 {
+			nonPortStr := parts[0]
+			nonPath := parts[1]
+
+			pos := base
+			pos.TaskIndex = taskIndex
+			taskIndex++
+			if cp.Skip(pos) {
+				counter.Skip(1)
+				continue
+			}
+			unit := cp.Begin(pos)
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(nonPortStr, nonPath string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				defer unit.Seal()
+
+				// 处理非循环端口
+				nonPort, err := strconv.Atoi(nonPortStr)
+				if err != nil {
+					log.Printf("无效的非循环端口: %s, 错误: %v", nonPortStr, err)
+					return
+				}
+				if !openPorts.Open(nonPort) {
+					counter.Skip(1)
+					return
+				}
+
+				// 获取当前时间戳，并截取前9位
+				timestamp := int(time.Now().Unix())
+				timestampStr := fmt.Sprintf("%d", timestamp)[:9]
+				timestampInt, err := strconv.Atoi(timestampStr)
+				if err != nil {
+					log.Fatalf("转换时间戳失败: %v", err)
+				}
+
+				// 将截取的时间戳减去5秒
+				timestampMinus5 := timestampInt - 5
+
+				// 获取当前日期时间，并按照指定格式格式化
+				timeFirst := time.Now().Format("2006010215")
+				// 动态替换 URL 中的变量
+				if strings.Contains(nonPath, "{timeFirst}") || strings.Contains(nonPath, "{timestampMinus5}") {
+					nonPath = strings.Replace(nonPath, "{timeFirst}", timeFirst, -1)
+					nonPath = strings.Replace(nonPath, "{timestampMinus5}", strconv.Itoa(timestampMinus5), -1)
+				}
+				// 添加任务到 worker pool
+				AddTaskToPool(workerPool, hostTarget(tmpl, host, nonPort, nonPath, srcAddr), cfg, successfulIPsCh, unit)
+			}(nonPortStr, nonPath)
+		} else {
+			log.Printf("无效的非循环端口路径格式: %s", nonPortPath)
+		}
+	}
+	wg.Wait()
+}
+
+// 按模板生成主机的目标，域名展开出的 IP 使用域名作为 Host 头和 SNI
+func hostTarget(tmpl network.Target, host string, port int, urlPath string, srcAddr string) *network.Target {
+	t := tmpl
+	t.IP = host
+	t.Port = port
+	t.Path = urlPath
+	t.SourceAddr = srcAddr
+	if t.Domain != "" {
+		t.Host = t.Domain
+		if port != 80 && port != 443 {
+			t.Host = net.JoinHostPort(t.Domain, strconv.Itoa(port))
+		}
+		t.Vhost = t.Domain
+	}
+	return &t
+}
+
+// 按地址排序并去除重复的 IP
+func sortIPs(ips []net.IP) []net.IP {
+	slices.SortFunc(ips, func(a, b net.IP) int {
+		return bytes.Compare(a.To16(), b.To16())
+	})
+	return slices.CompactFunc(ips, func(a, b net.IP) bool {
+		return a.Equal(b)
+	})
+}
+
 // 判断是否为IPv6地址
 func IsIPv6(ip net.IP) bool {
 	return ip.To4() == nil
@@ -415,40 +576,40 @@ func ParseCIDR(cidr string) (*net.IPNet, error) {
 	return ipNet, nil
 }
 
-// 循环生成指定数量的 CIDR 对应的 IP 列表，直到整个 CIDR 结束，并返回指定数量的 IP 地址
-func GenerateLimitedIPsFromCIDR(startIP net.IP, ipNet *net.IPNet, limit int) ([]string, bool) {
-	var ips []string
-	count := 0
-	for ip := startIP; ipNet.Contains(ip); incrementIP(ip) {
+// 从 startIP 开始生成最多 limit 个 CIDR 内的 IP，返回 IP 列表、下一批的起始 IP 以及整个 CIDR 是否已处理完毕
+func GenerateLimitedIPsFromCIDR(startIP net.IP, ipNet *net.IPNet, limit int) ([]string, net.IP, bool) {
+	ips := make([]string, 0, limit)
+	ip := make(net.IP, len(startIP))
+	copy(ip, startIP)
+	for ; ipNet.Contains(ip) && len(ips) < limit; incrementIP(ip) {
 		if isBadHost(ip.To4()) {
 			continue // 跳过主机部分为 "0" 或 "255" 的 IP
 		}
 		ips = append(ips, ip.String())
-		count++
-		if count >= limit {
-			break
-		}
 	}
+	return ips, ip, !ipNet.Contains(ip)
+}
 
-	// 如果整个 CIDR 已经处理完毕，则返回 true
-	if !ipNet.Contains(IncrementAndCopyIP(startIP, count)) {
-		return ips, true
+// 统计 [from, to) 之间实际扫描的主机数，IPv4 不计主机部分为 0 或 255 的地址
+func hostsBetween(from, to net.IP) int64 {
+	if a, b := from.To4(), to.To4(); a != nil && b != nil {
+		// [0, n) 中去掉 .0 和 .255 后的地址数
+		hosts := func(n int64) int64 { return n - n/256*2 - min(n%256, 1) }
+		return hosts(int64(binary.BigEndian.Uint32(b))) - hosts(int64(binary.BigEndian.Uint32(a)))
 	}
-
-	return ips, false
+	d := new(big.Int).Sub(new(big.Int).SetBytes(to.To16()), new(big.Int).SetBytes(from.To16()))
+	if !d.IsInt64() {
+		return math.MaxInt64
+	}
+	return d.Int64()
 }
 
-// 增加并复制 IP 地址
-func IncrementAndCopyIP(ip net.IP, n int) net.IP {
-	nextIP := make(net.IP, len(ip))
-	copy(nextIP, ip)
-	for i := len(nextIP) - 1; i >= 0; i-- {
-		nextIP[i] += byte(n)
-		if nextIP[i] > 0 {
-			break
-		}
+// 主机数×每个 IP 的任务数，溢出时取最大值
+func skippedTasks(hosts, perIP int64) int64 {
+	if perIP > 0 && hosts > math.MaxInt64/perIP {
+		return math.MaxInt64
 	}
-	return nextIP
+	return hosts * perIP
 }
 
 // 增加IP地址
@@ -47,33 +47,46 @@ func sanitizeFilename(filename string) string {
 	}, filename)
 }
 
-// 生成输出字符串
-func GenerateOutputString(ip string, port int, urlPath string, serverHeader string, cfg *config.Config, duration time.Duration, speed *float64) string {
+// 生成输出字符串，url 为完整地址，vhost 不为空时追加 Host
+// 配置了多个出口地址时追加成功请求使用的出口地址
+func GenerateOutputString(ip string, port int, url string, vhost string, product string, sourceAddr string, serverHeader string, cfg *config.Config, duration time.Duration, speed *float64) string {
 	if cfg.Outputs {
+		url = appendDetails(url, vhost, product, sourceAddr, cfg)
 		if speed != nil {
 			// 下载 TS 时输出耗时和速度
-			if !cfg.LogEnabled {
-				fmt.Printf("成功URL: Server:%s,http://%s:%d/%s, 耗时: %v, 速度: %.2f MB/s\n",
-					serverHeader, ip, port, urlPath, duration, *speed)
-			}
-			return fmt.Sprintf("Server:%s,http://%s:%d/%s, 耗时: %v, 速度: %.2f MB/s\n",
-				serverHeader, ip, port, urlPath, duration, *speed)
-		} else {
-			// 只是访问 URL，不下载 TS
-			if !cfg.LogEnabled {
-				fmt.Printf("成功URL: Server:%s,http://%s:%d/%s, 耗时: %v\n", serverHeader, ip, port, urlPath, duration)
-			}
-			return fmt.Sprintf("Server:%s,http://%s:%d/%s, 耗时: %v\n", serverHeader, ip, port, urlPath, duration)
+			return fmt.Sprintf("Server:%s,%s, 耗时: %v, 速度: %.2f MB/s\n",
+				serverHeader, url, duration, *speed)
 		}
+		// 只是访问 URL，不下载 TS
+		return fmt.Sprintf("Server:%s,%s, 耗时: %v\n", serverHeader, url, duration)
 	}
+	return fmt.Sprintf("%s:%d\n", ip, port)
+}
 
-	if !cfg.LogEnabled {
-		if speed != nil {
-			fmt.Printf("成功URL: Server:%s,http://%s:%d/%s, 耗时: %v, 速度: %.2f MB/s\n",
-				serverHeader, ip, port, urlPath, duration, *speed)
-		} else {
-			fmt.Printf("成功URL: Server:%s,http://%s:%d/%s, 耗时: %v\n", serverHeader, ip, port, urlPath, duration)
-		}
+// 关闭日志时在终端打印成功的URL
+func PrintSuccessURL(url string, vhost string, product string, sourceAddr string, serverHeader string, cfg *config.Config, duration time.Duration, speed *float64) {
+	if cfg.LogEnabled {
+		return
 	}
-	return fmt.Sprintf("%s:%d\n", ip, port)
+	url = appendDetails(url, vhost, product, sourceAddr, cfg)
+	if speed != nil {
+		fmt.Printf("成功URL: Server:%s,%s, 耗时: %v, 速度: %.2f MB/s\n",
+			serverHeader, url, duration, *speed)
+	} else {
+		fmt.Printf("成功URL: Server:%s,%s, 耗时: %v\n", serverHeader, url, duration)
+	}
+}
+
+// 在地址后追加虚拟主机、识别出的产品和出口地址
+func appendDetails(url string, vhost string, product string, sourceAddr string, cfg *config.Config) string {
+	if vhost != "" {
+		url = fmt.Sprintf("%s Host:%s", url, vhost)
+	}
+	if product != "" {
+		url = fmt.Sprintf("%s 产品:%s", url, product)
+	}
+	if sourceAddr != "" && len(cfg.SourceAddrs) > 1 {
+		url = fmt.Sprintf("%s 出口:%s", url, sourceAddr)
+	}
+	return url
 }
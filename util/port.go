@@ -3,6 +3,8 @@ package util
 import (
 	"strconv"
 	"strings"
+
+	"github.com/qist/iptv-static-scan/config"
 )
 
 // 解析端口范围
@@ -36,4 +38,30 @@ func ExpandPorts(portRanges []string) []int {
 	}
 
 	return ports
-}
\ No newline at end of file
+}
+
+// 每个 IP 展开的任务数：端口×路径 加上 非循环端口路径
+func PerIPTasks(cfg *config.Config) int64 {
+	n := int64(len(ExpandPorts(cfg.Ports)) * len(cfg.URLPaths))
+	for _, nonPortPath := range cfg.NonPortsPath {
+		parts := strings.SplitN(nonPortPath, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if _, err := strconv.Atoi(parts[0]); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// IP 目标按 vhosts 展开后每个任务的请求数，进度按请求计算，未配置 vhosts 时为 1
+// 域名目标和域名展开出的 IP 不按 vhosts 展开
+func VhostCount(cfg *config.Config) int64 {
+	return max(int64(len(cfg.Vhosts)), 1)
+}
+
+// 每个 IP 的请求数：任务数×虚拟主机数，用于统计进度
+func PerIPRequests(cfg *config.Config) int64 {
+	return PerIPTasks(cfg) * VhostCount(cfg)
+}